@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// $name or ${name} in an nginx log_format
+var nginxVariable = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+
+// \xHH, how nginx escapes quotes, backslashes and control characters in variables
+var nginxEscape = regexp.MustCompile(`\\x[0-9A-Fa-f]{2}`)
+
+// the access log formats known by name, written as nginx log_formats, apache's %l
+// (identd) becomes remote_ident as nginx always writes a - there
+var accessLogFormats = map[string]map[string]string{
+	"nginx": {
+		"combined": `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+		"main":     `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" "$http_x_forwarded_for"`,
+	},
+	"apache": {
+		"common":   `$remote_addr $remote_ident $remote_user [$time_local] "$request" $status $body_bytes_sent`,
+		"combined": `$remote_addr $remote_ident $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+	},
+}
+
+// variables shipped under a shorter field name
+var accessLogFieldNames = map[string]string{
+	"body_bytes_sent": "bytes",
+	"request_method":  "method",
+	"uri":             "path",
+	"args":            "query",
+	"query_string":    "query",
+	"server_protocol": "protocol",
+}
+
+// variables converted to numbers when they hold one, upstream variables can hold a list
+// when several upstreams were tried and are then left as they are
+var accessLogIntFields = map[string]bool{
+	"status":              true,
+	"body_bytes_sent":     true,
+	"bytes_sent":          true,
+	"request_length":      true,
+	"connection":          true,
+	"connection_requests": true,
+	"upstream_status":     true,
+}
+
+var accessLogFloatFields = map[string]bool{
+	"request_time":           true,
+	"msec":                   true,
+	"upstream_response_time": true,
+	"upstream_connect_time":  true,
+	"upstream_header_time":   true,
+}
+
+// accessLogParser - lines written by an nginx log_format, or an apache format expressed
+// as one, each variable becomes a field with - meaning it was empty
+type accessLogParser struct {
+	pattern *regexp.Regexp
+}
+
+// newAccessLogParser - the parser for logFormat if set, otherwise for the server's named format
+func newAccessLogParser(server string, format string, logFormat string) (lineParser, error) {
+	if logFormat != "" {
+		if server != "nginx" {
+			return nil, fmt.Errorf("%s parser has a log_format, which is only understood for nginx", server)
+		}
+		if format != "" {
+			return nil, fmt.Errorf("nginx parser has both a format and a log_format")
+		}
+	} else {
+		if format == "" {
+			format = "combined"
+		}
+		if logFormat = accessLogFormats[server][format]; logFormat == "" {
+			return nil, fmt.Errorf("%s parser has unknown format %q", server, format)
+		}
+	}
+
+	pattern, err := compileNginxLogFormat(logFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessLogParser{pattern: pattern}, nil
+}
+
+// compileNginxLogFormat - a regular expression matching the lines logFormat writes, a
+// variable matches up to the character that follows it in the format
+func compileNginxLogFormat(logFormat string) (*regexp.Regexp, error) {
+	references := nginxVariable.FindAllStringSubmatchIndex(logFormat, -1)
+	if len(references) == 0 {
+		return nil, fmt.Errorf("log_format %q has no variables", logFormat)
+	}
+
+	expression := "^"
+	previous := 0
+	for i, reference := range references {
+		expression += regexp.QuoteMeta(logFormat[previous:reference[0]])
+		previous = reference[1]
+
+		var name string
+		if reference[2] >= 0 {
+			name = logFormat[reference[2]:reference[3]]
+		} else {
+			name = logFormat[reference[4]:reference[5]]
+		}
+
+		var value string
+		switch {
+		case reference[1] == len(logFormat):
+			value = ".*"
+		case i+1 < len(references) && references[i+1][0] == reference[1]:
+			return nil, fmt.Errorf("log_format %q has nothing between $%s and the next variable", logFormat, name)
+		case strings.HasPrefix(name, "upstream_"):
+			// "10.0.0.1:80, 10.0.0.2:80 : 10.0.0.3:80", the separator after it may appear
+			// in the list, ", " separates upstreams and " : " internal redirects
+			value = `[^\s,]*(?:(?:, | : )[^\s,]*)*?`
+		default:
+			next, _ := utf8.DecodeRuneInString(logFormat[reference[1]:])
+			value = "[^" + regexp.QuoteMeta(string(next)) + "]*"
+		}
+		expression += "(?P<" + name + ">" + value + ")"
+	}
+	expression += regexp.QuoteMeta(logFormat[previous:]) + "$"
+
+	return regexp.Compile(expression)
+}
+
+func (parser *accessLogParser) parse(line string) (map[string]interface{}, bool) {
+	match := parser.pattern.FindStringSubmatch(line)
+	if match == nil {
+		return nil, false
+	}
+
+	fields := make(map[string]interface{})
+	for i, variable := range parser.pattern.SubexpNames() {
+		if variable == "" || match[i] == "-" || match[i] == "" {
+			continue
+		}
+
+		var value interface{} = nginxEscape.ReplaceAllStringFunc(match[i], unescapeNginx)
+		if accessLogIntFields[variable] {
+			if number, err := strconv.ParseInt(match[i], 10, 64); err == nil {
+				value = number
+			}
+		} else if accessLogFloatFields[variable] {
+			if number, err := strconv.ParseFloat(match[i], 64); err == nil {
+				value = number
+			}
+		}
+
+		field := variable
+		if name, ok := accessLogFieldNames[variable]; ok {
+			field = name
+		}
+		fields[field] = value
+	}
+
+	if request, ok := fields["request"].(string); ok {
+		splitRequest(request, fields)
+	} else if uri, ok := fields["request_uri"].(string); ok {
+		splitRequestTarget(uri, fields)
+	}
+
+	if text, ok := fields["time_local"].(string); ok {
+		if eventTime, err := time.Parse("02/Jan/2006:15:04:05 -0700", text); err == nil {
+			fields["@timestamp"] = eventTime.UTC().Format(time.RFC3339Nano)
+		}
+	} else if text, ok := fields["time_iso8601"].(string); ok {
+		if eventTime, err := time.Parse(time.RFC3339, text); err == nil {
+			fields["@timestamp"] = eventTime.UTC().Format(time.RFC3339Nano)
+		}
+	}
+
+	return fields, true
+}
+
+// splitRequest - method, path, query and protocol from a request line such as
+// GET /search?q=fling HTTP/1.1, anything else (e.g. a TLS handshake sent to a plain
+// port) is only kept as request
+func splitRequest(request string, fields map[string]interface{}) {
+	parts := strings.Split(request, " ")
+	if len(parts) < 2 || len(parts) > 3 {
+		return
+	}
+
+	fields["method"] = parts[0]
+	splitRequestTarget(parts[1], fields)
+	if len(parts) == 3 {
+		fields["protocol"] = parts[2]
+	}
+}
+
+func splitRequestTarget(target string, fields map[string]interface{}) {
+	path := target
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		path = target[:i]
+		if query := target[i+1:]; query != "" {
+			fields["query"] = query
+		}
+	}
+	fields["path"] = path
+}
+
+// unescapeNginx - the character a \xHH sequence stands for
+func unescapeNginx(escaped string) string {
+	value, err := strconv.ParseUint(escaped[2:], 16, 8)
+	if err != nil {
+		return escaped
+	}
+
+	return string([]byte{byte(value)})
+}
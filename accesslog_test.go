@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAccessLogParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    string
+		format    string
+		logFormat string
+		line      string
+		want      map[string]interface{}
+	}{
+		{
+			name:   "nginx combined",
+			server: "nginx",
+			line:   `10.0.0.1 - bob [01/Mar/2024:12:00:00 +0100] "GET /search?q=fling HTTP/1.1" 200 512 "-" "curl/8.0"`,
+			want: map[string]interface{}{
+				"remote_addr": "10.0.0.1", "remote_user": "bob", "time_local": "01/Mar/2024:12:00:00 +0100",
+				"@timestamp": "2024-03-01T11:00:00Z", "request": "GET /search?q=fling HTTP/1.1",
+				"method": "GET", "path": "/search", "query": "q=fling", "protocol": "HTTP/1.1",
+				"status": int64(200), "bytes": int64(512), "http_user_agent": "curl/8.0",
+			},
+		},
+		{
+			name:   "nginx main",
+			server: "nginx",
+			format: "main",
+			line:   `10.0.0.1 - - [01/Mar/2024:12:00:00 +0000] "POST /api HTTP/2.0" 201 0 "https://example.com/" "Mozilla/5.0 (X11)" "203.0.113.9, 10.0.0.2"`,
+			want: map[string]interface{}{
+				"remote_addr": "10.0.0.1", "time_local": "01/Mar/2024:12:00:00 +0000", "@timestamp": "2024-03-01T12:00:00Z",
+				"request": "POST /api HTTP/2.0", "method": "POST", "path": "/api", "protocol": "HTTP/2.0",
+				"status": int64(201), "bytes": int64(0), "http_referer": "https://example.com/",
+				"http_user_agent": "Mozilla/5.0 (X11)", "http_x_forwarded_for": "203.0.113.9, 10.0.0.2",
+			},
+		},
+		{
+			name:   "apache common",
+			server: "apache",
+			format: "common",
+			line:   `::1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 -`,
+			want: map[string]interface{}{
+				"remote_addr": "::1", "remote_user": "frank", "time_local": "10/Oct/2000:13:55:36 -0700",
+				"@timestamp": "2000-10-10T20:55:36Z", "request": "GET /a.gif HTTP/1.0",
+				"method": "GET", "path": "/a.gif", "protocol": "HTTP/1.0", "status": int64(200),
+			},
+		},
+		{
+			name:   "request that isn't http",
+			server: "apache",
+			line:   `1.2.3.4 - - [10/Oct/2000:13:55:36 -0700] "\x16\x03\x01" 400 226 "-" "-"`,
+			want: map[string]interface{}{
+				"remote_addr": "1.2.3.4", "time_local": "10/Oct/2000:13:55:36 -0700", "@timestamp": "2000-10-10T20:55:36Z",
+				"request": "\x16\x03\x01", "status": int64(400), "bytes": int64(226),
+			},
+		},
+		{
+			name:      "log_format",
+			server:    "nginx",
+			logFormat: `$time_iso8601 ${request_method} $request_uri $status $request_time $upstream_addr $upstream_response_time "$http_user_agent"`,
+			line:      `2024-03-01T12:00:00+02:00 GET /x?y=1 502 0.250 10.0.0.1:80, 10.0.0.2:80 0.100, 0.150 "say \x22hi\x22"`,
+			want: map[string]interface{}{
+				"time_iso8601": "2024-03-01T12:00:00+02:00", "@timestamp": "2024-03-01T10:00:00Z",
+				"method": "GET", "request_uri": "/x?y=1", "path": "/x", "query": "y=1", "status": int64(502),
+				"request_time": 0.25, "upstream_addr": "10.0.0.1:80, 10.0.0.2:80",
+				"upstream_response_time": "0.100, 0.150", "http_user_agent": `say "hi"`,
+			},
+		},
+		{
+			name:      "redirected upstreams followed by a comma",
+			server:    "nginx",
+			logFormat: `$upstream_addr, $status`,
+			line:      `10.0.0.1:80 : 10.0.0.2:80, 504`,
+			want:      map[string]interface{}{"upstream_addr": "10.0.0.1:80 : 10.0.0.2:80", "status": int64(504)},
+		},
+		{
+			name:   "not an access log line",
+			server: "nginx",
+			line:   "2024/03/01 12:00:00 [error] 1#0: oops",
+		},
+		{
+			name:   "truncated line",
+			server: "nginx",
+			line:   `10.0.0.1 - - [01/Mar/2024:12:00:00 +0000] "GET / HTTP/1.1" 200`,
+		},
+	}
+
+	for _, test := range tests {
+		parser, err := newAccessLogParser(test.server, test.format, test.logFormat)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		got, ok := parser.parse(test.line)
+		if test.want == nil {
+			if ok {
+				t.Errorf("%s: parsed %v, want no match", test.name, got)
+			}
+		} else if !ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v %v, want %v", test.name, got, ok, test.want)
+		}
+	}
+}
+
+func TestNewAccessLogParserErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		server    string
+		format    string
+		logFormat string
+	}{
+		{"log_format for apache", "apache", "", "$remote_addr"},
+		{"format and log_format", "nginx", "main", "$remote_addr"},
+		{"unknown format", "nginx", "fancy", ""},
+		{"log_format without variables", "nginx", "", "static text"},
+		{"variables with nothing between them", "nginx", "", "$remote_addr$status"},
+	}
+
+	for _, test := range tests {
+		if _, err := newAccessLogParser(test.server, test.format, test.logFormat); err == nil {
+			t.Errorf("%s: created, want an error", test.name)
+		}
+	}
+}
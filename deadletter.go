@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//FlingRetry - exponential backoff applied to failed deliveries before they are dead lettered
+type FlingRetry struct {
+	MaxAttempts    int `json:"max_attempts,omitempty"`
+	InitialBackoff int `json:"initial_backoff_ms,omitempty"`
+	MaxBackoff     int `json:"max_backoff_ms,omitempty"`
+}
+
+//FlingDeadLetter - where events go once an output gives up on them, an NDJSON
+// file, another named output, or both
+type FlingDeadLetter struct {
+	Path   string `json:"path,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// deadLetterBinding - outputs dead letters can be routed to, and the sends to them in flight
+type deadLetterBinding struct {
+	channels map[string]interface{}
+	abort    chan struct{} // closed once the binding is replaced, stops sends blocked on a full output
+	senders  sync.WaitGroup
+}
+
+// outputs dead letters can be routed to, bound once every output is running
+// and unbound when shutdown starts closing output channels
+var deadLetterOutputs struct {
+	sync.Mutex
+	binding *deadLetterBinding
+}
+
+// bindDeadLetterOutputs - routes dead letters to channels, returning once nothing is
+// sending to the outputs bound before so they can be closed, a dead letter still
+// waiting on one of them is given up on
+func bindDeadLetterOutputs(channels map[string]interface{}) {
+	deadLetterOutputs.Lock()
+	previous := deadLetterOutputs.binding
+	deadLetterOutputs.binding = &deadLetterBinding{channels: channels, abort: make(chan struct{})}
+	deadLetterOutputs.Unlock()
+
+	if previous != nil {
+		close(previous.abort)
+		previous.senders.Wait()
+	}
+}
+
+func (retry FlingRetry) withDefaults() FlingRetry {
+	if retry.MaxAttempts == 0 {
+		retry.MaxAttempts = 5
+	}
+	if retry.InitialBackoff == 0 {
+		retry.InitialBackoff = 500
+	}
+	if retry.MaxBackoff == 0 {
+		retry.MaxBackoff = 30000
+	}
+
+	return retry
+}
+
+// backoff - delay before retry number attempt, doubling each time with the
+// upper half jittered so a fleet of shippers doesn't retry in lockstep
+func (retry FlingRetry) backoff(attempt int) time.Duration {
+	delay := time.Duration(retry.InitialBackoff) * time.Millisecond
+	limit := time.Duration(retry.MaxBackoff) * time.Millisecond
+
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// isTransientPublishError - whether retrying a failed publish could succeed
+func isTransientPublishError(err error) bool {
+	if err == pubsub.ErrOversizedMessage {
+		return false
+	}
+
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented:
+		return false
+	}
+
+	return true
+}
+
+// deadLetterSink - accepts the events a single output could not deliver
+type deadLetterSink struct {
+	name   string
+	config *FlingDeadLetter
+	lock   sync.Mutex
+	file   *os.File
+}
+
+func newDeadLetterSink(name string, config *FlingDeadLetter) *deadLetterSink {
+	sink := &deadLetterSink{name: name, config: config}
+
+	if config != nil && config.Path != "" {
+		file, err := os.OpenFile(config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"OutputName": name,
+				"path":       config.Path,
+				"error":      err,
+			}).Error("Couldn't open dead letter file")
+		} else {
+			sink.file = file
+		}
+	}
+
+	return sink
+}
+
+// send - hands event to the dead letter output and/or file, with nowhere
+// configured (or reachable) the event is written to the log so it isn't lost silently
+func (sink *deadLetterSink) send(event FlingEvent, attempts int, reason error) {
+	delivered := false
+	countMetric(eventsDeadLettered, outputKey, sink.name)
+
+	if sink.config != nil && sink.config.Output != "" {
+		delivered = sink.sendToOutput(event, attempts, reason)
+	}
+	if sink.file != nil {
+		delivered = sink.writeToFile(event, attempts, reason) || delivered
+	}
+
+	if !delivered {
+		log.WithFields(log.Fields{
+			"OutputName": sink.name,
+			"UniqueID":   event.UniqueID,
+			"attempts":   attempts,
+			"error":      reason,
+		}).Error(fmt.Sprintf("Dropping undeliverable event %s", event.JSON))
+	}
+}
+
+func (sink *deadLetterSink) sendToOutput(event FlingEvent, attempts int, reason error) bool {
+	// the send may block on a full output, so it mustn't hold the lock a reload needs
+	deadLetterOutputs.Lock()
+	binding := deadLetterOutputs.binding
+	if binding != nil {
+		binding.senders.Add(1)
+	}
+	deadLetterOutputs.Unlock()
+
+	if binding == nil {
+		return false
+	}
+	defer binding.senders.Done()
+
+	channel, ok := binding.channels[sink.config.Output]
+	if !ok {
+		return false
+	}
+
+	// the same map may have been dispatched to other outputs, annotate a copy
+	logEntry := make(map[string]interface{}, len(event.JSON)+1)
+	for k, v := range event.JSON {
+		logEntry[k] = v
+	}
+	logEntry["fling.dead_letter"] = map[string]interface{}{
+		"output":   sink.name,
+		"error":    reason.Error(),
+		"attempts": attempts,
+	}
+
+	return channel.(*outputQueue).sendUnless(FlingEvent{UniqueID: event.UniqueID, JSON: logEntry}, binding.abort)
+}
+
+func (sink *deadLetterSink) writeToFile(event FlingEvent, attempts int, reason error) bool {
+	record := map[string]interface{}{
+		"@timestamp": get3339Time(),
+		"output":     sink.name,
+		"error":      reason.Error(),
+		"attempts":   attempts,
+		"event":      event.JSON,
+	}
+
+	line, marshalErr := json.Marshal(record)
+	if marshalErr != nil {
+		// the event itself is what failed to marshal, keep what we can of it
+		record["event"] = fmt.Sprintf("%v", event.JSON)
+		line, _ = json.Marshal(record)
+	}
+	line = append(line, '\n')
+
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+
+	if _, writeErr := sink.file.Write(line); writeErr != nil {
+		log.WithFields(log.Fields{
+			"OutputName": sink.name,
+			"path":       sink.config.Path,
+			"error":      writeErr,
+		}).Error("Couldn't write dead letter file")
+		return false
+	}
+
+	return true
+}
+
+func (sink *deadLetterSink) close() {
+	if sink.file != nil {
+		sink.file.Close()
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterRebindReleasesBlockedSend(t *testing.T) {
+	// a full output with the block policy
+	full := &outputQueue{name: "dlq", policy: overflowBlock, channel: make(chan FlingEvent)}
+	bindDeadLetterOutputs(map[string]interface{}{"dlq": full})
+	defer bindDeadLetterOutputs(nil)
+
+	sink := newDeadLetterSink("out", &FlingDeadLetter{Output: "dlq"})
+	sent := make(chan bool)
+	go func() {
+		sent <- sink.sendToOutput(FlingEvent{JSON: map[string]interface{}{"message": "x"}}, 1, errors.New("failed"))
+	}()
+
+	// give the send time to block
+	time.Sleep(100 * time.Millisecond)
+	rebound := make(chan bool)
+	go func() {
+		bindDeadLetterOutputs(nil)
+		close(rebound)
+	}()
+
+	select {
+	case <-rebound:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rebinding waited on a send blocked on a full output")
+	}
+	if <-sent {
+		t.Error("send to a full output that was unbound reported delivery")
+	}
+
+	// once unbound nothing is sent, so the output can be closed safely
+	close(full.channel)
+	if sink.sendToOutput(FlingEvent{JSON: map[string]interface{}{}}, 1, errors.New("failed")) {
+		t.Error("send after unbinding reported delivery")
+	}
+}
+
+func TestValidateDeadLetterLoops(t *testing.T) {
+	pubsub := func(name string, deadLetter string) FlingOutPubSub {
+		return FlingOutPubSub{Name: name, Project: "p", Topic: "t", DeadLetter: &FlingDeadLetter{Output: deadLetter}}
+	}
+
+	tests := []struct {
+		name    string
+		outputs []FlingOutPubSub
+		loop    string
+	}{
+		{"chain", []FlingOutPubSub{pubsub("a", "b"), pubsub("b", "c"), {Name: "c", Project: "p", Topic: "t"}}, ""},
+		{"self", []FlingOutPubSub{pubsub("a", "a")}, ""},
+		{"pair", []FlingOutPubSub{pubsub("a", "b"), pubsub("b", "a")}, "a -> b -> a"},
+		{"three", []FlingOutPubSub{pubsub("a", "b"), pubsub("b", "c"), pubsub("c", "a")}, "a -> b -> c -> a"},
+		{"into a loop", []FlingOutPubSub{pubsub("x", "a"), pubsub("a", "b"), pubsub("b", "a")}, "a -> b -> a"},
+	}
+
+	for _, test := range tests {
+		problems, _ := validateConfig(FlingConfig{Output: FlingOutput{PubSubs: test.outputs}})
+
+		var loops []string
+		for _, problem := range problems {
+			if strings.Contains(problem, "loop") {
+				loops = append(loops, problem)
+			}
+		}
+		switch {
+		case test.loop == "" && len(loops) > 0:
+			t.Errorf("%s: unexpected %v", test.name, loops)
+		case test.loop != "" && (len(loops) != 1 || !strings.HasSuffix(loops[0], test.loop)):
+			t.Errorf("%s: got %v, want one loop %s", test.name, loops, test.loop)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// restart policies, what the exec input does once its command exits
+const (
+	restartAlways    = "always"
+	restartOnFailure = "on_failure"
+	restartNever     = "never"
+)
+
+//FlingInExec - runs a command and ships each line it writes to stdout or stderr
+type FlingInExec struct {
+	Command      string            `json:"command"`
+	Args         []string          `json:"args,omitempty"`
+	Env          map[string]string `json:"env,omitempty"` // added to fling's own environment
+	Dir          string            `json:"dir,omitempty"`
+	Restart      string            `json:"restart,omitempty"`      // always (default), on_failure or never
+	StopTimeout  int               `json:"stop_timeout,omitempty"` // seconds between SIGTERM and SIGKILL, default 10
+	IsJSON       bool              `json:"is_json"`
+	MaxLineBytes int               `json:"max_line_bytes,omitempty"` // longer lines are truncated, default 1MB
+	Outputs      []string          `json:"outputs"`
+	Injections   []FlingInjection  `json:"injections"`
+}
+
+func (input FlingInExec) restart() string {
+	if input.Restart == "" {
+		return restartAlways
+	}
+
+	return input.Restart
+}
+
+// source - how the input is labelled in events, metrics and logs
+func (input FlingInExec) source() string {
+	return strings.Join(append([]string{input.Command}, input.Args...), " ")
+}
+
+func handleInExecs(inputs []FlingInExec) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input exec " + input.source(),
+			key:     configKey("exec", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { execInWorker(group.ctx, input, outputs) })
+			},
+		})
+	}
+
+	return configured
+}
+
+// execInWorker - runs the command, restarting it according to its restart policy,
+// until ctx is cancelled, backing off while it keeps exiting soon after starting
+func execInWorker(ctx context.Context, input FlingInExec, outputs map[string]interface{}) {
+	restarter := newExecRestarter(input.restart())
+
+	for {
+		started := time.Now()
+		err := runExecCommand(ctx, input, outputs)
+		if ctx.Err() != nil {
+			return
+		}
+
+		fields := log.Fields{
+			"command": input.source(),
+			"ran_for": time.Since(started).String(),
+		}
+		if err != nil {
+			fields["error"] = err
+		}
+
+		delay, restart := restarter.next(err, time.Since(started))
+		if !restart {
+			log.WithFields(fields).Info("Command exited, not restarting it")
+			// the input is still configured, it just has nothing left to do
+			<-ctx.Done()
+			return
+		}
+
+		fields["restart_in"] = delay.String()
+		log.WithFields(fields).Warn("Command exited, restarting it")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// execRestarter - applies a restart policy to a command each time it exits
+type execRestarter struct {
+	policy   string
+	backoff  FlingRetry
+	failures int // exits in a row that came soon after starting
+}
+
+func newExecRestarter(policy string) *execRestarter {
+	return &execRestarter{
+		policy:  policy,
+		backoff: FlingRetry{InitialBackoff: 1000, MaxBackoff: 60000}.withDefaults(),
+	}
+}
+
+// next - the delay before restarting a command that exited with err after running for
+// ranFor, false if the policy says it isn't restarted
+func (restarter *execRestarter) next(err error, ranFor time.Duration) (time.Duration, bool) {
+	if restarter.policy == restartNever || (err == nil && restarter.policy == restartOnFailure) {
+		return 0, false
+	}
+
+	if ranFor > time.Minute {
+		restarter.failures = 0
+	}
+	restarter.failures++
+
+	return restarter.backoff.backoff(restarter.failures), true
+}
+
+// runExecCommand - runs the command once, shipping its output, and returns how it exited,
+// cancelling ctx sends it SIGTERM and then SIGKILL if it hasn't exited after stop_timeout
+func runExecCommand(ctx context.Context, input FlingInExec, outputs map[string]interface{}) error {
+	cmd := exec.Command(input.Command, input.Args...)
+	cmd.Dir = input.Dir
+	cmd.Env = os.Environ()
+	var names []string
+	for name := range input.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd.Env = append(cmd.Env, name+"="+input.Env[name])
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"command": input.source(),
+		"pid":     cmd.Process.Pid,
+	}).Info("Started command")
+
+	exited := make(chan bool)
+	defer close(exited)
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+
+		cmd.Process.Signal(syscall.SIGTERM)
+		timeout := input.StopTimeout
+		if timeout == 0 {
+			timeout = 10
+		}
+
+		select {
+		case <-exited:
+		case <-time.After(time.Duration(timeout) * time.Second):
+			log.WithFields(log.Fields{
+				"command": input.source(),
+			}).Warn("Command didn't exit after SIGTERM, killing it")
+			cmd.Process.Kill()
+		}
+	}()
+
+	// every line has to be read before Wait closes the pipes
+	var readers sync.WaitGroup
+	for stream, pipe := range map[string]io.Reader{"stdout": stdout, "stderr": stderr} {
+		stream, pipe := stream, pipe
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			readCommandOutput(input, stream, pipe, outputs)
+		}()
+	}
+	readers.Wait()
+
+	return cmd.Wait()
+}
+
+func readCommandOutput(input FlingInExec, stream string, pipe io.Reader, outputs map[string]interface{}) {
+	maxLineBytes := input.MaxLineBytes
+	if maxLineBytes == 0 {
+		maxLineBytes = 1024 * 1024
+	}
+
+	// read like a file line from a file named after the command
+	file := FlingInFile{
+		Path:       input.source(),
+		IsJSON:     input.IsJSON,
+		Outputs:    input.Outputs,
+		Injections: input.Injections,
+	}
+	fields := map[string]interface{}{"stream": stream}
+
+	err := readLines(pipe, maxLineBytes, func(line string) {
+		countMetric(linesRead, inputKey, input.source())
+		processInFileLine(line, fields, file, outputs)
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"command": input.source(),
+			"stream":  stream,
+			"error":   err,
+		}).Error(fmt.Sprintf("Couldn't read command %s", stream))
+	}
+}
+
+// readLines - calls handle with each line read until EOF, lines longer than
+// maxLineBytes are truncated but read to the end so the writer never blocks
+func readLines(source io.Reader, maxLineBytes int, handle func(line string)) error {
+	reader := bufio.NewReader(source)
+	var line []byte
+
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if room := maxLineBytes - len(line); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			line = append(line, chunk...)
+		}
+		if isPrefix {
+			continue
+		}
+
+		handle(string(line))
+		line = line[:0]
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLines(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+
+	tests := []struct {
+		name         string
+		text         string
+		maxLineBytes int
+		want         []string
+	}{
+		{"lines", "one\ntwo\r\n\nlast", 100, []string{"one", "two", "", "last"}},
+		{"truncated", "abcdefgh\nij\n", 4, []string{"abcd", "ij"}},
+		{"longer than the read buffer", long + "\nafter\n", 5000, []string{long[:5000], "after"}},
+		{"empty", "", 100, nil},
+	}
+
+	for _, test := range tests {
+		var lines []string
+		err := readLines(strings.NewReader(test.text), test.maxLineBytes, func(line string) {
+			lines = append(lines, line)
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("%s: got %d lines %.40q, want %d %.40q", test.name, len(lines), lines, len(test.want), test.want)
+		}
+	}
+}
+
+func TestRunExecCommandTagsStreams(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+	input := FlingInExec{
+		Command: "sh",
+		Args:    []string{"-c", "echo out; echo err >&2; echo $GREETING; exit 3"},
+		Env:     map[string]string{"GREETING": "hello"},
+		Outputs: []string{"out"},
+	}
+
+	if err := runExecCommand(context.Background(), input, map[string]interface{}{"out": queue}); err == nil {
+		t.Error("command exiting 3 returned no error")
+	}
+
+	var got []string
+	for len(queue.channel) > 0 {
+		event := <-queue.channel
+		got = append(got, event.JSON["stream"].(string)+": "+event.JSON["message"].(string))
+		if source := event.JSON["fling.source"]; source != input.source() {
+			t.Errorf("fling.source %v, want %q", source, input.source())
+		}
+	}
+	// stdout and stderr are read concurrently, only the order within a stream is kept
+	sort.Strings(got)
+	if want := []string{"stderr: err", "stdout: hello", "stdout: out"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExecRestarter(t *testing.T) {
+	failed := errors.New("exit status 1")
+
+	tests := []struct {
+		policy string
+		err    error
+		want   bool
+	}{
+		{restartAlways, nil, true},
+		{restartAlways, failed, true},
+		{restartOnFailure, nil, false},
+		{restartOnFailure, failed, true},
+		{restartNever, nil, false},
+		{restartNever, failed, false},
+	}
+
+	for _, test := range tests {
+		if _, restart := newExecRestarter(test.policy).next(test.err, time.Second); restart != test.want {
+			t.Errorf("%s after %v: restart %v, want %v", test.policy, test.err, restart, test.want)
+		}
+	}
+}
+
+func TestExecRestarterBacksOff(t *testing.T) {
+	restarter := newExecRestarter(restartAlways)
+
+	// each quick exit doubles the delay, jittered within its upper half, up to a minute
+	for attempt, limit := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second, time.Minute, time.Minute} {
+		delay, _ := restarter.next(nil, time.Second)
+		if delay < limit/2 || delay > limit {
+			t.Errorf("quick exit %d: delay %v, want between %v and %v", attempt+1, delay, limit/2, limit)
+		}
+	}
+
+	// a command that ran for a while starts again from the shortest delay
+	if delay, _ := restarter.next(nil, 2*time.Minute); delay > time.Second {
+		t.Errorf("delay after a long run %v, want at most 1s", delay)
+	}
+}
+
+func TestExecInWorkerGivesUp(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+	input := FlingInExec{Command: "sh", Args: []string{"-c", "echo ran"}, Restart: restartNever, Outputs: []string{"out"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan bool)
+	go func() {
+		execInWorker(ctx, input, map[string]interface{}{"out": queue})
+		close(stopped)
+	}()
+
+	// the shortest restart delay is half a second, a restart would have run by now
+	time.Sleep(time.Second)
+	if len(queue.channel) != 1 {
+		t.Errorf("command ran %d times, want once", len(queue.channel))
+	}
+
+	select {
+	case <-stopped:
+		t.Error("worker returned before it was cancelled")
+	default:
+	}
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("worker didn't return once cancelled")
+	}
+}
+
+func TestExecInWorkerStopsCommand(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+	input := FlingInExec{Command: "sh", Args: []string{"-c", "echo started; exec sleep 60"}, Outputs: []string{"out"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan bool)
+	go func() {
+		execInWorker(ctx, input, map[string]interface{}{"out": queue})
+		close(stopped)
+	}()
+
+	select {
+	case <-queue.channel:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command didn't start")
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Error("command wasn't stopped by SIGTERM")
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingInForward - a Fluent Forward protocol server, so fluentd and fluent-bit
+// agents can point their forward output at fling
+type FlingInForward struct {
+	Listen          string           `json:"listen"`
+	TLS             *FlingTLS        `json:"tls,omitempty"`
+	TagField        string           `json:"tag_field,omitempty"`         // field the fluent tag is stored in, default tag
+	MaxMessageBytes int              `json:"max_message_bytes,omitempty"` // largest value in a message, after decompression, default 32MB
+	Outputs         []string         `json:"outputs"`
+	Injections      []FlingInjection `json:"injections"`
+}
+
+// forwardEntry - one record and its event time
+type forwardEntry struct {
+	time   time.Time
+	record map[string]interface{}
+}
+
+func (input FlingInForward) tagField() string {
+	if input.TagField == "" {
+		return "tag"
+	}
+
+	return input.TagField
+}
+
+func (input FlingInForward) maxMessageBytes() int {
+	if input.MaxMessageBytes == 0 {
+		return 32 * 1024 * 1024
+	}
+
+	return input.MaxMessageBytes
+}
+
+// source - how the input is labelled in metrics and logs
+func (input FlingInForward) source() string {
+	return "forward " + input.Listen
+}
+
+func handleInForwards(inputs []FlingInForward) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input " + input.source(),
+			key:     configKey("forward", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { forwardInWorker(group.ctx, input, outputs) })
+			},
+		})
+	}
+
+	return configured
+}
+
+func forwardInWorker(ctx context.Context, input FlingInForward, outputs map[string]interface{}) {
+	listener := listenUntilDone(ctx, "tcp", input.Listen, input.TLS)
+	if listener == nil {
+		return
+	}
+
+	serveConnections(ctx, listener, func(conn net.Conn) {
+		sender := "forward://" + conn.RemoteAddr().String()
+		decoder := newMsgpackDecoder(conn, input.maxMessageBytes())
+
+		for {
+			message, err := decoder.decode()
+			if err == io.EOF {
+				return
+			}
+			if err == nil {
+				err = processForwardMessage(conn, sender, message, input, outputs)
+			}
+
+			if err != nil {
+				if ctx.Err() == nil {
+					log.WithFields(log.Fields{
+						"listen": input.Listen,
+						"sender": sender,
+						"error":  err,
+					}).Error("Closing forward connection")
+				}
+				return
+			}
+		}
+	})
+}
+
+// processForwardMessage - dispatches every record in message, acking it if the sender
+// asked for one and every output accepted every record
+func processForwardMessage(conn net.Conn, sender string, message interface{}, input FlingInForward, outputs map[string]interface{}) error {
+	tag, entries, options, err := decodeForwardMessage(message, input.maxMessageBytes())
+	if err != nil {
+		countMetric(parseFailures, inputKey, input.source())
+		return err
+	}
+
+	accepted := true
+	for _, entry := range entries {
+		countMetric(linesRead, inputKey, input.source())
+
+		logEntry := entry.record
+		logEntry[input.tagField()] = tag
+		logEntry["fling.source"] = sender
+		if _, ok := logEntry["@timestamp"]; !ok {
+			logEntry["@timestamp"] = entry.time.UTC().Format(time.RFC3339Nano)
+		}
+
+		handleInjections(&logEntry, input.Injections)
+
+		accepted = dispatchEntry(FlingEvent{UniqueID: "", JSON: logEntry}, input.Outputs, outputs) && accepted
+	}
+
+	// without an ack the sender retries the chunk, which is what we want if part of it was dropped
+	if chunk, ok := options["chunk"].(string); ok && accepted {
+		if _, err := conn.Write(encodeMsgpackStringMap(map[string]string{"ack": chunk})); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeForwardMessage - the tag, records and options of a message in any of the forward
+// protocol's modes, Message [tag, time, record, options?], Forward [tag, [[time, record], ...], options?]
+// or (Compressed)PackedForward [tag, <[time, record] entries packed into a str or bin>, options?]
+func decodeForwardMessage(message interface{}, maxLength int) (string, []forwardEntry, map[string]interface{}, error) {
+	fields, ok := message.([]interface{})
+	if !ok || len(fields) < 2 {
+		return "", nil, nil, errors.New("forward message is not an array of at least 2 elements")
+	}
+
+	tag, ok := msgpackString(fields[0])
+	if !ok {
+		return "", nil, nil, errors.New("forward message tag is not a string")
+	}
+
+	optionsAt := 2
+	var entries []forwardEntry
+	var err error
+
+	switch events := fields[1].(type) {
+	case []interface{}:
+		for _, event := range events {
+			entry, entryErr := decodeForwardEntry(event)
+			if entryErr != nil {
+				return "", nil, nil, entryErr
+			}
+			entries = append(entries, entry)
+		}
+	case string, []byte:
+		var packed []byte
+		if s, isString := events.(string); isString {
+			packed = []byte(s)
+		} else {
+			packed = events.([]byte)
+		}
+		entries, err = decodePackedForward(packed, forwardOptions(fields, optionsAt), maxLength)
+		if err != nil {
+			return "", nil, nil, err
+		}
+	default:
+		if len(fields) < 3 {
+			return "", nil, nil, errors.New("forward message has a time but no record")
+		}
+		entry, entryErr := decodeForwardEntry([]interface{}{fields[1], fields[2]})
+		if entryErr != nil {
+			return "", nil, nil, entryErr
+		}
+		entries = append(entries, entry)
+		optionsAt = 3
+	}
+
+	return tag, entries, forwardOptions(fields, optionsAt), nil
+}
+
+func forwardOptions(fields []interface{}, at int) map[string]interface{} {
+	if len(fields) > at {
+		if options, ok := fields[at].(map[string]interface{}); ok {
+			return options
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// decodePackedForward - a stream of msgpack [time, record] arrays, gzipped when the
+// options say compressed
+func decodePackedForward(packed []byte, options map[string]interface{}, maxLength int) ([]forwardEntry, error) {
+	var stream io.Reader = bytes.NewReader(packed)
+
+	if compressed, _ := msgpackString(options["compressed"]); compressed != "" {
+		if compressed != "gzip" {
+			return nil, fmt.Errorf("unsupported compression %q", compressed)
+		}
+		gzipReader, err := gzip.NewReader(stream)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(gzipReader, int64(maxLength)+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxLength {
+			return nil, fmt.Errorf("compressed entries exceed the %d byte limit", maxLength)
+		}
+		stream = bytes.NewReader(data)
+	}
+
+	var entries []forwardEntry
+	decoder := newMsgpackDecoder(stream, maxLength)
+	for {
+		event, err := decoder.decode()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		entry, err := decodeForwardEntry(event)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func decodeForwardEntry(event interface{}) (forwardEntry, error) {
+	pair, ok := event.([]interface{})
+	if !ok || len(pair) != 2 {
+		return forwardEntry{}, errors.New("forward entry is not a [time, record] pair")
+	}
+
+	eventTime, err := forwardTime(pair[0])
+	if err != nil {
+		return forwardEntry{}, err
+	}
+
+	record, ok := normaliseMsgpack(pair[1]).(map[string]interface{})
+	if !ok {
+		return forwardEntry{}, errors.New("forward record is not a map")
+	}
+
+	return forwardEntry{time: eventTime, record: record}, nil
+}
+
+// forwardTime - whole seconds, or the EventTime extension carrying nanoseconds too
+func forwardTime(value interface{}) (time.Time, error) {
+	switch t := value.(type) {
+	case int64:
+		return time.Unix(t, 0), nil
+	case uint64:
+		return time.Unix(int64(t), 0), nil
+	case float64:
+		return time.Unix(0, int64(t*float64(time.Second))), nil
+	case msgpackExt:
+		if t.Type == 0 && len(t.Data) == 8 {
+			return time.Unix(int64(binary.BigEndian.Uint32(t.Data[:4])), int64(binary.BigEndian.Uint32(t.Data[4:]))), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid forward event time %v", value)
+}
+
+func msgpackString(value interface{}) (string, bool) {
+	switch s := value.(type) {
+	case string:
+		return s, true
+	case []byte:
+		return string(s), true
+	}
+
+	return "", false
+}
+
+// normaliseMsgpack - older fluentd packs strings as bin, turn them back into strings so
+// records marshal to JSON the way they were logged
+func normaliseMsgpack(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normaliseMsgpack(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normaliseMsgpack(item)
+		}
+	case msgpackExt:
+		return map[string]interface{}{"type": v.Type, "data": v.Data}
+	}
+
+	return value
+}
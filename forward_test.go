@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return compressed.Bytes()
+}
+
+func TestDecodeForwardMessage(t *testing.T) {
+	// [1, {"a": "b"}] and [2, {"a": "c"}] packed back to back
+	packed := []byte("\x92\x01\x81\xa1a\xa1b\x92\x02\x81\xa1a\xa1c")
+	packedEntries := []forwardEntry{
+		{time: time.Unix(1, 0), record: map[string]interface{}{"a": "b"}},
+		{time: time.Unix(2, 0), record: map[string]interface{}{"a": "c"}},
+	}
+	eventTime := msgpackExt{Type: 0, Data: []byte{0, 0, 0, 10, 0, 0, 0, 5}}
+
+	tests := []struct {
+		name    string
+		message interface{}
+		entries []forwardEntry
+		options map[string]interface{}
+		err     bool
+	}{
+		{
+			name:    "message",
+			message: []interface{}{"app", int64(100), map[string]interface{}{"a": []byte("b")}, map[string]interface{}{"chunk": "c1"}},
+			entries: []forwardEntry{{time: time.Unix(100, 0), record: map[string]interface{}{"a": "b"}}},
+			options: map[string]interface{}{"chunk": "c1"},
+		},
+		{
+			name: "forward",
+			message: []interface{}{[]byte("app"), []interface{}{
+				[]interface{}{uint64(1), map[string]interface{}{"a": "b"}},
+				[]interface{}{eventTime, map[string]interface{}{"a": "c"}},
+			}},
+			entries: []forwardEntry{
+				{time: time.Unix(1, 0), record: map[string]interface{}{"a": "b"}},
+				{time: time.Unix(10, 5), record: map[string]interface{}{"a": "c"}},
+			},
+			options: map[string]interface{}{},
+		},
+		{
+			name:    "packed forward",
+			message: []interface{}{"app", string(packed)},
+			entries: packedEntries,
+			options: map[string]interface{}{},
+		},
+		{
+			name:    "compressed packed forward",
+			message: []interface{}{"app", gzipBytes(t, packed), map[string]interface{}{"compressed": "gzip"}},
+			entries: packedEntries,
+			options: map[string]interface{}{"compressed": "gzip"},
+		},
+		{name: "not an array", message: map[string]interface{}{}, err: true},
+		{name: "only a tag", message: []interface{}{"app"}, err: true},
+		{name: "tag not a string", message: []interface{}{int64(1), int64(1), map[string]interface{}{}}, err: true},
+		{name: "time without a record", message: []interface{}{"app", int64(1)}, err: true},
+		{name: "record not a map", message: []interface{}{"app", int64(1), "text"}, err: true},
+		{name: "bad time", message: []interface{}{"app", []interface{}{[]interface{}{"now", map[string]interface{}{}}}}, err: true},
+		{name: "short event time", message: []interface{}{"app", msgpackExt{Type: 0, Data: []byte{1}}, map[string]interface{}{}}, err: true},
+		{name: "entry not a pair", message: []interface{}{"app", []interface{}{[]interface{}{int64(1)}}}, err: true},
+		{name: "packed garbage", message: []interface{}{"app", "\xc1"}, err: true},
+		{name: "packed entry cut off", message: []interface{}{"app", string(packed[:5])}, err: true},
+		{name: "unknown compression", message: []interface{}{"app", string(packed), map[string]interface{}{"compressed": "zstd"}}, err: true},
+		{name: "not gzip", message: []interface{}{"app", string(packed), map[string]interface{}{"compressed": "gzip"}}, err: true},
+		{
+			name:    "decompresses past the limit",
+			message: []interface{}{"app", gzipBytes(t, make([]byte, 4096)), map[string]interface{}{"compressed": "gzip"}},
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		tag, entries, options, err := decodeForwardMessage(test.message, 1024)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: decoded %v, want an error", test.name, entries)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if tag != "app" {
+			t.Errorf("%s: tag %q, want app", test.name, tag)
+		}
+		if !reflect.DeepEqual(entries, test.entries) {
+			t.Errorf("%s: entries %v, want %v", test.name, entries, test.entries)
+		}
+		if !reflect.DeepEqual(options, test.options) {
+			t.Errorf("%s: options %v, want %v", test.name, options, test.options)
+		}
+	}
+}
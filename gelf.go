@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingInGELF - a Graylog Extended Log Format listener, chunked and compressed messages over
+// UDP or null delimited messages over TCP
+type FlingInGELF struct {
+	Listen          string           `json:"listen"`
+	Protocol        string           `json:"protocol,omitempty"` // udp (default), tcp or tls
+	TLS             *FlingTLS        `json:"tls,omitempty"`
+	ChunkTimeout    int              `json:"chunk_timeout,omitempty"`     // seconds to wait for the rest of a chunked message, default 5
+	MaxMessageBytes int              `json:"max_message_bytes,omitempty"` // after reassembly and decompression, default 8MB
+	Outputs         []string         `json:"outputs"`
+	Injections      []FlingInjection `json:"injections"`
+}
+
+// the magic bytes every GELF chunk starts with, and most chunks a message may be split into
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+const gelfMaxChunks = 128
+
+func (input FlingInGELF) protocol() string {
+	if input.Protocol == "" {
+		return "udp"
+	}
+
+	return input.Protocol
+}
+
+func (input FlingInGELF) chunkTimeout() time.Duration {
+	if input.ChunkTimeout == 0 {
+		return 5 * time.Second
+	}
+
+	return time.Duration(input.ChunkTimeout) * time.Second
+}
+
+func (input FlingInGELF) maxMessageBytes() int {
+	if input.MaxMessageBytes == 0 {
+		return 8 * 1024 * 1024
+	}
+
+	return input.MaxMessageBytes
+}
+
+// source - how the input is labelled in metrics and logs
+func (input FlingInGELF) source() string {
+	return "gelf " + input.protocol() + " " + input.Listen
+}
+
+func handleInGELFs(inputs []FlingInGELF) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input " + input.source(),
+			key:     configKey("gelf", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { gelfInWorker(group.ctx, input, outputs) })
+			},
+		})
+	}
+
+	return configured
+}
+
+func gelfInWorker(ctx context.Context, input FlingInGELF, outputs map[string]interface{}) {
+	if input.protocol() == "udp" {
+		conn := listenPacketUntilDone(ctx, "udp", input.Listen)
+		if conn == nil {
+			return
+		}
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+
+		chunks := newGELFChunks(input)
+		buffer := make([]byte, 65536)
+		for {
+			// wake up now and then so incomplete messages time out even when nothing arrives
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, addr, err := conn.ReadFrom(buffer)
+			chunks.expire(time.Now())
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			} else if err != nil {
+				if ctx.Err() == nil {
+					log.WithFields(log.Fields{
+						"listen": input.Listen,
+						"error":  err,
+					}).Error("Couldn't read GELF datagram")
+				}
+				return
+			}
+
+			sender := "udp://" + addr.String()
+			data := buffer[:n]
+			if bytes.HasPrefix(data, gelfChunkMagic) {
+				data, err = chunks.add(sender, data, time.Now())
+				if err != nil {
+					log.WithFields(log.Fields{
+						"sender": sender,
+						"error":  err,
+					}).Debug("Discarding GELF chunk")
+					countMetric(parseFailures, inputKey, input.source())
+					continue
+				}
+				if data == nil {
+					continue
+				}
+			}
+
+			processGELFMessage(data, sender, input, outputs)
+		}
+	}
+
+	var tlsConfig *FlingTLS
+	if input.protocol() == "tls" {
+		tlsConfig = input.TLS
+	}
+	listener := listenUntilDone(ctx, "tcp", input.Listen, tlsConfig)
+	if listener == nil {
+		return
+	}
+
+	serveConnections(ctx, listener, func(conn net.Conn) {
+		sender := input.protocol() + "://" + conn.RemoteAddr().String()
+
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 4096), input.maxMessageBytes())
+		scanner.Split(splitGELFFrames)
+		for scanner.Scan() {
+			processGELFMessage(scanner.Bytes(), sender, input, outputs)
+		}
+
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			log.WithFields(log.Fields{
+				"listen": input.Listen,
+				"sender": sender,
+				"error":  err,
+			}).Error("Closing GELF connection")
+		}
+	})
+}
+
+// splitGELFFrames - messages over TCP are terminated by a null byte
+func splitGELFFrames(data []byte, atEOF bool) (int, []byte, error) {
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		return end + 1, data[:end], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// gelfMessage - the chunks of one message received so far
+type gelfMessage struct {
+	sender   string
+	chunks   [][]byte
+	received int
+	bytes    int
+	started  time.Time
+}
+
+// gelfChunks - reassembles chunked messages, keyed by sender and message id so two
+// senders picking the same id can't mix their chunks
+type gelfChunks struct {
+	pending     map[string]*gelfMessage
+	input       FlingInGELF
+	lastExpired time.Time
+}
+
+func newGELFChunks(input FlingInGELF) *gelfChunks {
+	return &gelfChunks{pending: make(map[string]*gelfMessage), input: input}
+}
+
+// add - adds a chunk (magic, 8 byte message id, sequence number, sequence count, data),
+// returning the whole message once every chunk of it has arrived
+func (chunks *gelfChunks) add(sender string, chunk []byte, now time.Time) ([]byte, error) {
+	if len(chunk) < 12 {
+		return nil, errors.New("chunk is too short")
+	}
+
+	id := sender + " " + string(chunk[2:10])
+	sequence, count := int(chunk[10]), int(chunk[11])
+	if count == 0 || count > gelfMaxChunks || sequence >= count {
+		delete(chunks.pending, id)
+		return nil, fmt.Errorf("invalid chunk %d of %d", sequence, count)
+	}
+
+	message, ok := chunks.pending[id]
+	if !ok {
+		message = &gelfMessage{sender: sender, chunks: make([][]byte, count), started: now}
+		chunks.pending[id] = message
+	} else if len(message.chunks) != count {
+		delete(chunks.pending, id)
+		return nil, fmt.Errorf("chunk count changed from %d to %d", len(message.chunks), count)
+	}
+
+	if message.chunks[sequence] != nil {
+		// a duplicate, keep the first copy
+		return nil, nil
+	}
+	// the read buffer is reused for the next datagram
+	message.chunks[sequence] = append([]byte(nil), chunk[12:]...)
+	message.received++
+	message.bytes += len(chunk) - 12
+
+	if message.bytes > chunks.input.maxMessageBytes() {
+		delete(chunks.pending, id)
+		return nil, fmt.Errorf("chunked message exceeds the %d byte limit", chunks.input.maxMessageBytes())
+	}
+	if message.received < count {
+		return nil, nil
+	}
+
+	delete(chunks.pending, id)
+	return bytes.Join(message.chunks, nil), nil
+}
+
+// expire - discards messages still missing chunks after the chunk timeout
+func (chunks *gelfChunks) expire(now time.Time) {
+	if now.Sub(chunks.lastExpired) < time.Second {
+		return
+	}
+	chunks.lastExpired = now
+
+	for id, message := range chunks.pending {
+		if now.Sub(message.started) < chunks.input.chunkTimeout() {
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"listen":   chunks.input.Listen,
+			"sender":   message.sender,
+			"received": message.received,
+			"chunks":   len(message.chunks),
+		}).Warn("Discarding incomplete GELF message")
+		countMetric(parseFailures, inputKey, chunks.input.source())
+		delete(chunks.pending, id)
+	}
+}
+
+func processGELFMessage(data []byte, sender string, input FlingInGELF, outputs map[string]interface{}) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+
+	countMetric(linesRead, inputKey, input.source())
+
+	logEntry, parseErr := parseGELF(data, input.maxMessageBytes())
+	if parseErr != nil {
+		log.WithFields(log.Fields{
+			"sender": sender,
+			"error":  parseErr,
+		}).Debug("Couldn't parse GELF message")
+		countMetric(parseFailures, inputKey, input.source())
+		return
+	}
+
+	logEntry["fling.source"] = sender
+
+	if _, ok := logEntry["@timestamp"]; !ok {
+		logEntry["@timestamp"] = get3339Time()
+	}
+
+	handleInjections(&logEntry, input.Injections)
+
+	dispatchEntry(FlingEvent{UniqueID: "", JSON: logEntry}, input.Outputs, outputs)
+}
+
+// parseGELF - a GELF message, gzip or zlib compressed or not, as an event, short_message
+// becomes the message, level is kept and named in severity, and additional fields lose
+// their leading underscore without replacing any of the standard fields
+func parseGELF(data []byte, maxMessageBytes int) (map[string]interface{}, error) {
+	data, err := decompressGELF(data, maxMessageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	shortMessage, ok := fields["short_message"].(string)
+	if !ok {
+		return nil, errors.New("message has no short_message")
+	}
+
+	logEntry := make(map[string]interface{})
+	for name, value := range fields {
+		if strings.HasPrefix(name, "_") && len(name) > 1 {
+			logEntry[name[1:]] = value
+		}
+	}
+	for name, value := range fields {
+		switch {
+		case strings.HasPrefix(name, "_"), name == "version", name == "short_message", name == "timestamp":
+		default:
+			logEntry[name] = value
+		}
+	}
+
+	logEntry["message"] = shortMessage
+
+	// syslog levels, alert when the sender doesn't say
+	level := 1
+	if number, ok := fields["level"].(float64); ok {
+		level = int(number)
+	}
+	logEntry["level"] = level
+	if level >= 0 && level < len(syslogSeverities) {
+		logEntry["severity"] = syslogSeverities[level]
+	}
+
+	// seconds since the epoch, with optional decimal places
+	if timestamp, ok := fields["timestamp"].(float64); ok {
+		seconds := math.Floor(timestamp)
+		eventTime := time.Unix(int64(seconds), int64((timestamp-seconds)*float64(time.Second))).Round(time.Microsecond)
+		logEntry["@timestamp"] = eventTime.UTC().Format(time.RFC3339Nano)
+	}
+
+	return logEntry, nil
+}
+
+// decompressGELF - data as it is unless it starts with a gzip or zlib header
+func decompressGELF(data []byte, maxMessageBytes int) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		reader, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxMessageBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decompressed) > maxMessageBytes {
+		return nil, fmt.Errorf("decompressed message exceeds the %d byte limit", maxMessageBytes)
+	}
+
+	return decompressed, nil
+}
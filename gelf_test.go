@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func zlibBytes(t *testing.T, data []byte) []byte {
+	var compressed bytes.Buffer
+	writer := zlib.NewWriter(&compressed)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return compressed.Bytes()
+}
+
+func TestParseGELF(t *testing.T) {
+	message := `{"version":"1.1","host":"web","short_message":"hi","level":4,"timestamp":1700000000.25,"_user":"bob","_host":"ignored","_":"x"}`
+	parsed := map[string]interface{}{
+		"host": "web", "message": "hi", "level": 4, "severity": "warning",
+		"@timestamp": "2023-11-14T22:13:20.25Z", "user": "bob",
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want map[string]interface{}
+		err  bool
+	}{
+		{name: "plain", data: []byte(message), want: parsed},
+		{name: "gzip", data: gzipBytes(t, []byte(message)), want: parsed},
+		{name: "zlib", data: zlibBytes(t, []byte(message)), want: parsed},
+		{
+			name: "defaults",
+			data: []byte(`{"short_message":"hi","level":"high"}`),
+			want: map[string]interface{}{"message": "hi", "level": 1, "severity": "alert"},
+		},
+		{
+			name: "level out of range",
+			data: []byte(`{"short_message":"hi","level":-3}`),
+			want: map[string]interface{}{"message": "hi", "level": -3},
+		},
+		{name: "no short_message", data: []byte(`{"host":"web"}`), err: true},
+		{name: "short_message not a string", data: []byte(`{"short_message":3}`), err: true},
+		{name: "not json", data: []byte("hello"), err: true},
+		{name: "json array", data: []byte(`["hi"]`), err: true},
+		{name: "truncated gzip", data: gzipBytes(t, []byte(message))[:20], err: true},
+		{name: "gzip bomb", data: gzipBytes(t, []byte(`{"short_message":"`+strings.Repeat("a", 4096)+`"}`)), err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseGELF(test.data, 1024)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: parsed %v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// gelfChunk - chunk sequence of count of the message with id
+func gelfChunk(id string, sequence int, count int, data string) []byte {
+	chunk := append([]byte{0x1e, 0x0f}, []byte(id)...)
+	return append(append(chunk, byte(sequence), byte(count)), data...)
+}
+
+func TestGELFChunks(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		chunks   [][]byte
+		senders  []string
+		messages []string
+		errors   int
+	}{
+		{
+			name:     "in order",
+			chunks:   [][]byte{gelfChunk("msgid-01", 0, 2, "hel"), gelfChunk("msgid-01", 1, 2, "lo")},
+			messages: []string{"hello"},
+		},
+		{
+			name:     "out of order with a duplicate",
+			chunks:   [][]byte{gelfChunk("msgid-01", 2, 3, "c"), gelfChunk("msgid-01", 0, 3, "a"), gelfChunk("msgid-01", 2, 3, "x"), gelfChunk("msgid-01", 1, 3, "b")},
+			messages: []string{"abc"},
+		},
+		{
+			name:     "same id from two senders",
+			chunks:   [][]byte{gelfChunk("msgid-01", 0, 2, "a"), gelfChunk("msgid-01", 1, 2, "B"), gelfChunk("msgid-01", 1, 2, "b")},
+			senders:  []string{"one", "two", "one"},
+			messages: []string{"ab"},
+		},
+		{name: "too short", chunks: [][]byte{{0x1e, 0x0f, 1}}, errors: 1},
+		{name: "no chunks", chunks: [][]byte{gelfChunk("msgid-01", 0, 0, "a")}, errors: 1},
+		{name: "sequence past the count", chunks: [][]byte{gelfChunk("msgid-01", 2, 2, "a")}, errors: 1},
+		{name: "too many chunks", chunks: [][]byte{gelfChunk("msgid-01", 0, gelfMaxChunks+1, "a")}, errors: 1},
+		{
+			name:   "count changes",
+			chunks: [][]byte{gelfChunk("msgid-01", 0, 2, "a"), gelfChunk("msgid-01", 1, 3, "b")},
+			errors: 1,
+		},
+		{
+			name:   "larger than the limit",
+			chunks: [][]byte{gelfChunk("msgid-01", 0, 2, strings.Repeat("a", 600)), gelfChunk("msgid-01", 1, 2, strings.Repeat("b", 600))},
+			errors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		chunks := newGELFChunks(FlingInGELF{MaxMessageBytes: 1024})
+
+		var messages []string
+		errors := 0
+		for i, chunk := range test.chunks {
+			sender := "one"
+			if test.senders != nil {
+				sender = test.senders[i]
+			}
+			message, err := chunks.add(sender, chunk, now)
+			if err != nil {
+				errors++
+			} else if message != nil {
+				messages = append(messages, string(message))
+			}
+		}
+
+		if !reflect.DeepEqual(messages, test.messages) || errors != test.errors {
+			t.Errorf("%s: got %q and %d errors, want %q and %d", test.name, messages, errors, test.messages, test.errors)
+		}
+		if test.senders == nil && len(chunks.pending) != 0 {
+			t.Errorf("%s: %d messages left pending", test.name, len(chunks.pending))
+		}
+	}
+}
+
+func TestGELFChunksExpire(t *testing.T) {
+	now := time.Now()
+	chunks := newGELFChunks(FlingInGELF{ChunkTimeout: 5})
+	chunks.add("one", gelfChunk("msgid-01", 0, 2, "a"), now)
+
+	chunks.expire(now.Add(2 * time.Second))
+	if len(chunks.pending) != 1 {
+		t.Fatal("message expired before the chunk timeout")
+	}
+	chunks.expire(now.Add(6 * time.Second))
+	if len(chunks.pending) != 0 {
+		t.Fatal("incomplete message wasn't expired")
+	}
+}
+
+func TestSplitGELFFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		atEOF   bool
+		advance int
+		token   string
+	}{
+		{name: "terminated", data: "{}\x00{", advance: 3, token: "{}"},
+		{name: "empty frame", data: "\x00", advance: 1, token: ""},
+		{name: "incomplete", data: "{}"},
+		{name: "unterminated at eof", data: "{}", atEOF: true, advance: 2, token: "{}"},
+		{name: "nothing at eof", data: "", atEOF: true},
+	}
+
+	for _, test := range tests {
+		advance, token, err := splitGELFFrames([]byte(test.data), test.atEOF)
+		if err != nil || advance != test.advance || string(token) != test.token {
+			t.Errorf("%s: got %d %q %v, want %d %q", test.name, advance, token, err, test.advance, test.token)
+		}
+	}
+}
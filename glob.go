@@ -0,0 +1,377 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	fsnotify "gopkg.in/fsnotify.v1"
+)
+
+// how long the tail of a file that has left its glob keeps reading after its last line,
+// so lines written just before the file was deleted are still shipped
+const fileDrainQuiet = 5 * time.Second
+
+// fileTailer - a file the glob watcher is tailing
+type fileTailer struct {
+	drain    chan bool // closed once the file has left the glob
+	draining bool
+	done     chan bool // closed once the tail has stopped
+}
+
+// fileInGlobWatcher - tails every file matching the glob, picking up new files as
+// soon as their directory changes (or every glob_interval when it can't be watched)
+// and stopping the tail of any file that leaves the glob once it has been drained
+func fileInGlobWatcher(group *workerGroup, file FlingInFile, process func(file FlingInFile) lineProcessor) {
+	if file.GlobInterval == 0 {
+		file.GlobInterval = 30
+	}
+	var globPattern = file.Path
+	tailers := make(map[string]*fileTailer)
+
+	watcher := newGlobDirWatcher(globPattern)
+	defer watcher.close()
+
+	for {
+		log.WithFields(log.Fields{
+			"path": globPattern,
+		}).Debug("Checking for new files in glob")
+
+		paths, dirs, err := globFiles(globPattern)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  globPattern,
+				"error": err,
+			}).Error("Couldn't expand glob")
+		}
+		watcher.watch(dirs)
+
+		matched := make(map[string]bool)
+		for _, path := range paths {
+			if file.excluded(path) {
+				continue
+			}
+			matched[path] = true
+
+			if tailer, exists := tailers[path]; exists && !tailer.stopped() {
+				// a file that came back while its tail was draining is left to that tail until it
+				// goes quiet, the first check after that starts a new tail for it
+				continue
+			}
+			if file.tooOld(path) {
+				log.WithFields(log.Fields{
+					"path": path,
+				}).Debug("Ignoring file older than ignore_older")
+				continue
+			}
+
+			file.Path = path
+			tailers[path] = startGlobFileWorker(group, file, process)
+		}
+
+		for path, tailer := range tailers {
+			if tailer.stopped() {
+				delete(tailers, path)
+			} else if !matched[path] && !tailer.draining {
+				log.WithFields(log.Fields{
+					"path": path,
+				}).Info("File left glob, stopping its tail once drained")
+				tailer.draining = true
+				close(tailer.drain)
+			}
+		}
+
+		select {
+		case <-group.ctx.Done():
+			return
+		case <-time.After(time.Duration(file.GlobInterval) * time.Second):
+		case <-watcher.changed():
+		}
+	}
+}
+
+func (tailer *fileTailer) stopped() bool {
+	select {
+	case <-tailer.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// startGlobFileWorker - tails one of the files matching a glob, its goroutine belongs to
+// the group but may finish on its own once the file has left the glob
+func startGlobFileWorker(group *workerGroup, file FlingInFile, process func(file FlingInFile) lineProcessor) *fileTailer {
+	tailer := &fileTailer{drain: make(chan bool), done: make(chan bool)}
+
+	log.WithFields(log.Fields{
+		"path": file.Path,
+	}).Info("Adding tail for file")
+
+	group.workers.Add(1)
+	go func() {
+		defer group.workers.Done()
+		defer close(tailer.done)
+
+		fileInWorker(group.ctx, file, process(file), tailer.drain)
+
+		if _, err := os.Stat(file.Path); os.IsNotExist(err) && group.ctx.Err() == nil {
+			registry.forget(file.Path)
+		}
+	}()
+
+	return tailer
+}
+
+// excluded - whether path matches one of the file's exclude patterns, a pattern
+// without a directory is matched against the file name alone
+func (file FlingInFile) excluded(path string) bool {
+	for _, pattern := range file.Exclude {
+		if !strings.ContainsRune(pattern, filepath.Separator) {
+			if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+				return true
+			}
+		} else if matchGlob(pattern, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tooOld - whether path was last written to longer than ignore_older seconds ago
+func (file FlingInFile) tooOld(path string) bool {
+	if file.IgnoreOlder == 0 {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return time.Since(info.ModTime()) > time.Duration(file.IgnoreOlder)*time.Second
+}
+
+// globFiles - the files matching pattern, where a ** segment matches any number of
+// directories, and the directories those files could appear in
+func globFiles(pattern string) ([]string, []string, error) {
+	pattern = filepath.Clean(pattern)
+
+	if !isRecursiveGlob(pattern) {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		parents, _ := filepath.Glob(filepath.Dir(pattern))
+
+		var files, dirs []string
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				files = append(files, match)
+			}
+		}
+		for _, parent := range parents {
+			if info, err := os.Stat(parent); err == nil && info.IsDir() {
+				dirs = append(dirs, parent)
+			}
+		}
+		return files, dirs, nil
+	}
+
+	root := globRoot(pattern)
+	var files, dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// unreadable, or removed while we were walking
+			if info != nil && info.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		} else if matchGlob(pattern, path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files, dirs, err
+}
+
+func isRecursiveGlob(pattern string) bool {
+	for _, segment := range strings.Split(pattern, string(filepath.Separator)) {
+		if segment == "**" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globRoot - the directory a recursive glob is walked from, its leading segments
+// that have no wildcards in them
+func globRoot(pattern string) string {
+	segments := strings.Split(pattern, string(filepath.Separator))
+
+	var root []string
+	for _, segment := range segments[:len(segments)-1] {
+		if strings.ContainsAny(segment, `*?[\`) {
+			break
+		}
+		root = append(root, segment)
+	}
+
+	if len(root) == 1 && root[0] == "" {
+		return string(filepath.Separator)
+	} else if len(root) == 0 {
+		return "."
+	}
+
+	return strings.Join(root, string(filepath.Separator))
+}
+
+// matchGlob - filepath.Match, plus ** segments matching any number of directories
+func matchGlob(pattern string, path string) bool {
+	separator := string(filepath.Separator)
+
+	return matchGlobSegments(strings.Split(filepath.Clean(pattern), separator), strings.Split(filepath.Clean(path), separator))
+}
+
+func matchGlobSegments(pattern []string, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchGlobSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+		if matched, _ := filepath.Match(pattern[0], path[0]); !matched {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+
+	return len(path) == 0
+}
+
+// globDirWatcher - notices files being created, renamed or deleted in the directories a
+// glob's files are in, a nil watcher (inotify unavailable) leaves it to polling
+type globDirWatcher struct {
+	watcher *fsnotify.Watcher
+	pattern string
+	dirs    map[string]bool
+	failed  map[string]bool // directories already warned about
+	changes chan bool
+}
+
+func newGlobDirWatcher(pattern string) *globDirWatcher {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  pattern,
+			"error": err,
+		}).Warn("Couldn't watch glob directories, polling for new files instead")
+		return nil
+	}
+
+	dirWatcher := &globDirWatcher{
+		watcher: watcher,
+		pattern: pattern,
+		dirs:    make(map[string]bool),
+		failed:  make(map[string]bool),
+		changes: make(chan bool, 1),
+	}
+	go dirWatcher.run()
+
+	return dirWatcher
+}
+
+// run - turns events into at most one pending change, inotify reports every write to
+// every file in the directory too and those don't change what the glob matches
+func (dirWatcher *globDirWatcher) run() {
+	for {
+		select {
+		case event, ok := <-dirWatcher.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			select {
+			case dirWatcher.changes <- true:
+			default:
+			}
+		case err, ok := <-dirWatcher.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.WithFields(log.Fields{
+				"path":  dirWatcher.pattern,
+				"error": err,
+			}).Warn("Glob directory watch failed, relying on polling until the next check")
+		}
+	}
+}
+
+// watch - watches dirs, and stops watching directories no longer in them
+func (dirWatcher *globDirWatcher) watch(dirs []string) {
+	if dirWatcher == nil {
+		return
+	}
+
+	current := make(map[string]bool)
+	for _, dir := range dirs {
+		current[dir] = true
+		if dirWatcher.dirs[dir] {
+			continue
+		}
+
+		if err := dirWatcher.watcher.Add(dir); err != nil {
+			// e.g. out of inotify watches, this directory is left to polling
+			if dirWatcher.failed[dir] {
+				continue
+			}
+			dirWatcher.failed[dir] = true
+			log.WithFields(log.Fields{
+				"path":  dir,
+				"error": err,
+			}).Warn("Couldn't watch directory, polling it for new files instead")
+			continue
+		}
+		dirWatcher.dirs[dir] = true
+	}
+
+	for dir := range dirWatcher.dirs {
+		if !current[dir] {
+			dirWatcher.watcher.Remove(dir)
+			delete(dirWatcher.dirs, dir)
+		}
+	}
+}
+
+// changed - receives once files may have been added to or removed from a watched directory
+func (dirWatcher *globDirWatcher) changed() <-chan bool {
+	if dirWatcher == nil {
+		return nil
+	}
+
+	return dirWatcher.changes
+}
+
+func (dirWatcher *globDirWatcher) close() {
+	if dirWatcher != nil {
+		dirWatcher.watcher.Close()
+	}
+}
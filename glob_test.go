@@ -0,0 +1,288 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/tail"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/var/log/*.log", "/var/log/app.log", true},
+		{"/var/log/*.log", "/var/log/app/app.log", false},
+		{"/var/log/**/*.log", "/var/log/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/b/c/app.log", true},
+		{"/var/log/**/*.log", "/var/log/a/b/app.txt", false},
+		{"/var/log/**", "/var/log/a/b", true},
+		{"/var/**/app/*.log", "/var/log/app/x.log", true},
+		{"/var/**/app/*.log", "/var/log/web/x.log", false},
+		{"/var/log//./*.log", "/var/log/app.log", true},
+		{"/var/log/[ab].log", "/var/log/c.log", false},
+	}
+
+	for _, test := range tests {
+		if got := matchGlob(test.pattern, test.path); got != test.want {
+			t.Errorf("%s against %s: got %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
+
+func TestGlobRoot(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"/var/log/**/*.log", "/var/log"},
+		{"/var/log/app-*/**/*.log", "/var/log"},
+		{"/**/*.log", "/"},
+		{"logs/**/*.log", "logs"},
+		{"**/*.log", "."},
+		{"/var/log/[ab]/**", "/var/log"},
+	}
+
+	for _, test := range tests {
+		if got := globRoot(test.pattern); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	file := FlingInFile{Exclude: []string{"*.gz", "/var/log/old/**"}}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/var/log/app.log", false},
+		{"/var/log/app.log.1.gz", true},
+		{"/var/log/nested/app.log.gz", true},
+		{"/var/log/old/app.log", true},
+		{"/var/log/old/a/b/app.log", true},
+		{"/var/log/older/app.log", false},
+	}
+
+	for _, test := range tests {
+		if got := file.excluded(test.path); got != test.want {
+			t.Errorf("%s: excluded %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestTooOld(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fresh := filepath.Join(dir, "fresh.log")
+	writeTestFile(t, fresh, "new\n")
+	stale := filepath.Join(dir, "stale.log")
+	writeTestFile(t, stale, "old\n")
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		ignoreOlder int
+		path        string
+		want        bool
+	}{
+		{"fresh", 3600, fresh, false},
+		{"stale", 3600, stale, true},
+		{"stale without ignore_older", 0, stale, false},
+		{"missing", 3600, filepath.Join(dir, "missing.log"), false},
+	}
+
+	for _, test := range tests {
+		file := FlingInFile{IgnoreOlder: test.ignoreOlder}
+		if got := file.tooOld(test.path); got != test.want {
+			t.Errorf("%s: too old %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGlobFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, path := range []string{"a.log", "b.txt", "app/c.log", "app/deep/d.log", "web/e.log"} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, path, "line\n")
+	}
+	// a directory matching the pattern isn't a file to tail
+	if err := os.Mkdir(filepath.Join(dir, "dir.log"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	in := func(paths ...string) []string {
+		var joined []string
+		for _, path := range paths {
+			joined = append(joined, filepath.Join(dir, path))
+		}
+		return joined
+	}
+
+	tests := []struct {
+		pattern   string
+		wantFiles []string
+		wantDirs  []string
+	}{
+		{"*.log", in("a.log"), in("")},
+		{"*/*.log", in("app/c.log", "web/e.log"), in("app", "dir.log", "web")},
+		{"**/*.log", in("a.log", "app/c.log", "app/deep/d.log", "web/e.log"), in("", "app", "app/deep", "dir.log", "web")},
+		{"app/**/*.log", in("app/c.log", "app/deep/d.log"), in("app", "app/deep")},
+		{"missing/**/*.log", nil, nil},
+	}
+
+	for _, test := range tests {
+		files, dirs, _ := globFiles(filepath.Join(dir, test.pattern))
+		sort.Strings(files)
+		sort.Strings(dirs)
+		if !reflect.DeepEqual(files, test.wantFiles) {
+			t.Errorf("%s: files %q, want %q", test.pattern, files, test.wantFiles)
+		}
+		if !reflect.DeepEqual(dirs, test.wantDirs) {
+			t.Errorf("%s: dirs %q, want %q", test.pattern, dirs, test.wantDirs)
+		}
+	}
+}
+
+func TestGlobDirWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "existing.log")
+	writeTestFile(t, existing, "line\n")
+
+	watcher := newGlobDirWatcher(filepath.Join(dir, "*.log"))
+	if watcher == nil {
+		t.Skip("inotify isn't available")
+	}
+	defer watcher.close()
+	watcher.watch([]string{dir})
+
+	changed := func() bool {
+		select {
+		case <-watcher.changed():
+			return true
+		case <-time.After(200 * time.Millisecond):
+			return false
+		}
+	}
+
+	// writes to a file don't change what the glob matches
+	appended, err := os.OpenFile(existing, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appended.WriteString("more\n")
+	appended.Close()
+	if changed() {
+		t.Error("writing to a file was reported as a change")
+	}
+
+	writeTestFile(t, filepath.Join(dir, "new.log"), "line\n")
+	if !changed() {
+		t.Error("creating a file wasn't reported")
+	}
+
+	os.Remove(existing)
+	if !changed() {
+		t.Error("removing a file wasn't reported")
+	}
+
+	// a directory that is no longer in the glob stops being watched
+	watcher.watch(nil)
+	writeTestFile(t, filepath.Join(dir, "ignored.log"), "line\n")
+	if changed() {
+		t.Error("change reported in a directory that is no longer watched")
+	}
+}
+
+func TestGlobDirWatcherUnavailable(t *testing.T) {
+	var watcher *globDirWatcher
+
+	// without inotify every method is a no-op, and changes come from polling
+	watcher.watch([]string{"/var/log"})
+	if watcher.changed() != nil {
+		t.Error("nil watcher has a changes channel")
+	}
+	watcher.close()
+}
+
+func TestFileInGlobWatcherPicksUpNewFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(previous *offsetRegistry) { registry = previous }(registry)
+	registry = newOffsetRegistry(filepath.Join(dir, "registry.json"))
+
+	lines := make(chan string, 10)
+	process := func(file FlingInFile) lineProcessor {
+		return func(line *tail.Line) int {
+			if line != nil {
+				lines <- filepath.Base(file.Path) + ": " + line.Text
+			}
+			return 0
+		}
+	}
+
+	logs := filepath.Join(dir, "logs")
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// polling alone wouldn't look again for an hour, a new file is found by watching its directory
+	file := FlingInFile{Path: filepath.Join(logs, "*.log"), IsGlob: true, GlobInterval: 3600, StartPosition: "beginning"}
+
+	group := newWorkerGroup("input glob")
+	group.start(func() { fileInGlobWatcher(group, file, process) })
+	defer func() {
+		group.cancel()
+		group.waitUntil(time.Now().Add(5 * time.Second))
+	}()
+
+	// give the watcher time to make its first check
+	time.Sleep(200 * time.Millisecond)
+	writeTestFile(t, filepath.Join(logs, "new.log"), "hello\n")
+	writeTestFile(t, filepath.Join(logs, "new.txt"), "ignored\n")
+
+	select {
+	case line := <-lines:
+		if line != "new.log: hello" {
+			t.Errorf("got %q, want the line from new.log", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("new file wasn't picked up")
+	}
+	select {
+	case line := <-lines:
+		t.Errorf("unexpected line %q", line)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// %{PATTERN}, %{PATTERN:field} or %{PATTERN:field:type}
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([^:}]+))?(?::(\w+))?\}`)
+
+// references nested deeper than this are assumed to be a pattern including itself
+const grokMaxDepth = 50
+
+// grokBuiltinPatterns - the bundled pattern library, in the same format as pattern files,
+// based on the logstash patterns but rewritten for Go's regexp, which has no lookarounds
+const grokBuiltinPatterns = `
+USERNAME [a-zA-Z0-9._-]+
+USER %{USERNAME}
+EMAILLOCALPART [a-zA-Z0-9!#$%&'*+/=?^_{|}~-]+(?:\.[a-zA-Z0-9!#$%&'*+/=?^_{|}~-]+)*
+EMAILADDRESS %{EMAILLOCALPART}@%{HOSTNAME}
+INT (?:[+-]?(?:[0-9]+))
+BASE10NUM [+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)
+NUMBER (?:%{BASE10NUM})
+BASE16NUM [+-]?(?:0x)?(?:[0-9A-Fa-f]+)
+POSINT \b(?:[1-9][0-9]*)\b
+NONNEGINT \b(?:[0-9]+)\b
+WORD \b\w+\b
+NOTSPACE \S+
+SPACE \s*
+DATA .*?
+GREEDYDATA .*
+QUOTEDSTRING "(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'
+QS %{QUOTEDSTRING}
+UUID [A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}
+
+# networking
+MAC (?:[A-Fa-f0-9]{2}[:-]){5}[A-Fa-f0-9]{2}
+IPV4 (?:(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)
+IPV6 (?:(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,6}:%{IPV4}|(?:[0-9A-Fa-f]{1,4}:){1,7}:|(?:[0-9A-Fa-f]{1,4}:){1,6}:[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,5}(?::[0-9A-Fa-f]{1,4}){1,2}|(?:[0-9A-Fa-f]{1,4}:){1,4}(?::[0-9A-Fa-f]{1,4}){1,3}|(?:[0-9A-Fa-f]{1,4}:){1,3}(?::[0-9A-Fa-f]{1,4}){1,4}|(?:[0-9A-Fa-f]{1,4}:){1,2}(?::[0-9A-Fa-f]{1,4}){1,5}|[0-9A-Fa-f]{1,4}:(?::[0-9A-Fa-f]{1,4}){1,6}|::%{IPV4}|:(?:(?::[0-9A-Fa-f]{1,4}){1,7}|:))(?:%\w+)?
+IP (?:%{IPV6}|%{IPV4})
+HOSTNAME \b(?:[0-9A-Za-z][0-9A-Za-z-]{0,62})(?:\.(?:[0-9A-Za-z][0-9A-Za-z-]{0,62}))*\.?
+IPORHOST (?:%{IP}|%{HOSTNAME})
+HOSTPORT %{IPORHOST}:%{POSINT}
+
+# paths and urls
+UNIXPATH (?:/[\w_%!$@:.,+~-]*)+
+WINPATH (?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+
+PATH (?:%{UNIXPATH}|%{WINPATH})
+URIPROTO [A-Za-z][A-Za-z0-9+\-.]+
+URIHOST %{IPORHOST}(?::%{POSINT})?
+URIPATH (?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+
+URIPARAM \?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*
+URIPATHPARAM %{URIPATH}(?:%{URIPARAM})?
+URI %{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?
+
+# dates and times
+MONTH \b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b
+MONTHNUM (?:0?[1-9]|1[0-2])
+MONTHDAY (?:0[1-9]|[12][0-9]|3[01]|[1-9])
+DAY (?:Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?)
+YEAR (?:\d\d){1,2}
+HOUR (?:2[0123]|[01]?[0-9])
+MINUTE (?:[0-5][0-9])
+SECOND (?:(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?)
+TIME %{HOUR}:%{MINUTE}(?::%{SECOND})?
+DATE_US %{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}
+DATE_EU %{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}
+DATE %{DATE_US}|%{DATE_EU}
+DATESTAMP %{DATE}[- ]%{TIME}
+TZ (?:[APMCE][SD]T|UTC|GMT)
+ISO8601_TIMEZONE (?:Z|[+-]%{HOUR}(?::?%{MINUTE}))
+TIMESTAMP_ISO8601 %{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?
+DATESTAMP_RFC822 %{DAY} %{MONTH} %{MONTHDAY} %{YEAR} %{TIME} %{TZ}
+DATESTAMP_OTHER %{DAY} %{MONTH} %{MONTHDAY} %{TIME} %{TZ} %{YEAR}
+HTTPDATE %{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}
+LOGLEVEL (?:[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo(?:rmation)?|INFO(?:RMATION)?|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?)
+
+# syslog
+SYSLOGTIMESTAMP %{MONTH} +%{MONTHDAY} %{TIME}
+PROG [\x21-\x5a\x5c\x5e-\x7e]+
+SYSLOGPROG %{PROG:program}(?:\[%{POSINT:pid:int}\])?
+SYSLOGHOST %{IPORHOST}
+SYSLOGFACILITY <%{NONNEGINT:facility:int}.%{NONNEGINT:priority:int}>
+SYSLOGBASE %{SYSLOGTIMESTAMP:timestamp} (?:%{SYSLOGFACILITY} )?%{SYSLOGHOST:logsource} %{SYSLOGPROG}:
+SYSLOGLINE %{SYSLOGBASE} %{GREEDYDATA:message}
+
+# web servers
+HTTPDUSER %{EMAILADDRESS}|%{USER}
+COMMONAPACHELOG %{IPORHOST:clientip} %{HTTPDUSER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response:int} (?:%{NUMBER:bytes:int}|-)
+COMBINEDAPACHELOG %{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}
+HTTPDERROR_DATE %{DAY} %{MONTH} %{MONTHDAY} %{TIME} %{YEAR}
+HTTPD_ERRORLOG \[%{HTTPDERROR_DATE:timestamp}\] \[(?:%{WORD:module}:)?%{LOGLEVEL:loglevel}\] (?:\[pid %{POSINT:pid:int}(?::tid %{NUMBER:tid:int})?\] )?(?:\[client %{IPORHOST:clientip}(?::%{POSINT:clientport:int})?\] )?%{GREEDYDATA:message}
+NGINX_ERROR_DATE %{YEAR}/%{MONTHNUM}/%{MONTHDAY} %{TIME}
+NGINXERROR %{NGINX_ERROR_DATE:timestamp} \[%{LOGLEVEL:loglevel}\] %{POSINT:pid:int}#%{NONNEGINT:tid:int}: (?:\*%{NONNEGINT:connection:int} )?%{DATA:message}(?:, client: %{IPORHOST:clientip})?(?:, server: %{DATA:server})?(?:, request: "%{DATA:request}")?(?:, upstream: "%{DATA:upstream}")?(?:, host: "%{DATA:host}")?(?:, referrer: "%{DATA:referrer}")?$
+
+# phusion passenger
+PASSENGER_PREFIX \[ %{WORD:passenger_level} %{TIMESTAMP_ISO8601:timestamp} %{POSINT:pid:int}/%{NOTSPACE:thread} %{NOTSPACE:source} \]:
+PASSENGERLOG %{PASSENGER_PREFIX} %{GREEDYDATA:message}
+`
+
+// grokCapture - the field a named group of a compiled grok pattern is stored in
+type grokCapture struct {
+	field    string
+	typeHint string
+}
+
+// grokExpression - one compiled grok pattern
+type grokExpression struct {
+	pattern  *regexp.Regexp
+	captures map[string]grokCapture // group name to field
+}
+
+// grokParser - tries each of its patterns in order, the first to match sets the fields
+type grokParser struct {
+	expressions []grokExpression
+}
+
+// newGrokParser - compiles patterns against the bundled library, overridden by
+// definitions from the pattern files and then by definitions
+func newGrokParser(patterns []string, patternFiles []string, definitions map[string]string) (lineParser, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("grok parser has no patterns")
+	}
+
+	library, err := parseGrokDefinitions(grokBuiltinPatterns)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range patternFiles {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fileDefinitions, err := parseGrokDefinitions(string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for name, definition := range fileDefinitions {
+			library[name] = definition
+		}
+	}
+	for name, definition := range definitions {
+		library[name] = definition
+	}
+
+	parser := &grokParser{}
+	for _, pattern := range patterns {
+		expression := grokExpression{captures: make(map[string]grokCapture)}
+		expanded, err := expandGrok(pattern, library, expression.captures, 0)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+
+		if expression.pattern, err = regexp.Compile(expanded); err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+		parser.expressions = append(parser.expressions, expression)
+	}
+
+	return parser, nil
+}
+
+// parseGrokDefinitions - "NAME pattern" lines, blank lines and lines starting with # are skipped
+func parseGrokDefinitions(text string) (map[string]string, error) {
+	definitions := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[1]) == "" {
+			return nil, fmt.Errorf("line %d is not a pattern name followed by its pattern", number)
+		}
+		definitions[fields[0]] = strings.TrimSpace(fields[1])
+	}
+
+	return definitions, scanner.Err()
+}
+
+// expandGrok - pattern with every reference replaced by its definition, references with a
+// field become named groups recorded in captures
+func expandGrok(pattern string, library map[string]string, captures map[string]grokCapture, depth int) (string, error) {
+	if depth > grokMaxDepth {
+		return "", fmt.Errorf("patterns nested more than %d deep, does one refer to itself?", grokMaxDepth)
+	}
+
+	var expandErr error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if expandErr != nil {
+			return ""
+		}
+		match := grokReference.FindStringSubmatch(reference)
+		name, field, typeHint := match[1], match[2], match[3]
+
+		definition, ok := library[name]
+		if !ok {
+			expandErr = fmt.Errorf("unknown pattern %s", name)
+			return ""
+		}
+		if typeHint != "" && !validTypeHint(typeHint) {
+			expandErr = fmt.Errorf("%s has unknown type %q", reference, typeHint)
+			return ""
+		}
+
+		inner, err := expandGrok(definition, library, captures, depth+1)
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+
+		if field == "" {
+			return "(?:" + inner + ")"
+		}
+		// field names may have characters a group name can't, so groups are numbered
+		group := "grok" + strconv.Itoa(len(captures))
+		captures[group] = grokCapture{field: field, typeHint: typeHint}
+		return "(?P<" + group + ">" + inner + ")"
+	})
+
+	return expanded, expandErr
+}
+
+func (parser *grokParser) parse(line string) (map[string]interface{}, bool) {
+	for _, expression := range parser.expressions {
+		match := expression.pattern.FindStringSubmatchIndex(line)
+		if match == nil {
+			continue
+		}
+
+		fields := make(map[string]interface{})
+		for i, group := range expression.pattern.SubexpNames() {
+			capture, ok := expression.captures[group]
+			// optional groups that didn't take part in the match are left out
+			if !ok || match[2*i] < 0 {
+				continue
+			}
+
+			var value interface{} = line[match[2*i]:match[2*i+1]]
+			if capture.typeHint != "" {
+				if converted, err := convertField(value.(string), capture.typeHint); err == nil {
+					value = converted
+				}
+			}
+			fields[capture.field] = value
+		}
+		return fields, true
+	}
+
+	return nil, false
+}
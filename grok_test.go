@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGrokBuiltinPatternsCompile(t *testing.T) {
+	library, err := parseGrokDefinitions(grokBuiltinPatterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name := range library {
+		if _, err := newGrokParser([]string{"%{" + name + "}"}, nil, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestGrokParser(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		defs     map[string]string
+		line     string
+		want     map[string]interface{}
+	}{
+		{
+			name:     "combined apache log",
+			patterns: []string{"%{COMBINEDAPACHELOG}"},
+			line:     `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08"`,
+			want: map[string]interface{}{
+				"clientip": "127.0.0.1", "ident": "-", "auth": "frank", "timestamp": "10/Oct/2000:13:55:36 -0700",
+				"verb": "GET", "request": "/a.gif", "httpversion": "1.0", "response": int64(200), "bytes": int64(2326),
+				"referrer": `"http://example.com/"`, "agent": `"Mozilla/4.08"`,
+			},
+		},
+		{
+			name:     "combined apache log without bytes",
+			patterns: []string{"%{COMBINEDAPACHELOG}"},
+			line:     `::1 - - [10/Oct/2000:13:55:36 -0700] "\x16\x03" 400 - "-" "-"`,
+			want: map[string]interface{}{
+				"clientip": "::1", "ident": "-", "auth": "-", "timestamp": "10/Oct/2000:13:55:36 -0700",
+				"rawrequest": `\x16\x03`, "response": int64(400), "referrer": `"-"`, "agent": `"-"`,
+			},
+		},
+		{
+			name:     "syslog line",
+			patterns: []string{"%{SYSLOGLINE}"},
+			line:     "Mar  1 12:00:00 host sshd[42]: accepted",
+			want: map[string]interface{}{
+				"timestamp": "Mar  1 12:00:00", "logsource": "host", "program": "sshd", "pid": int64(42), "message": "accepted",
+			},
+		},
+		{
+			name:     "first matching pattern wins",
+			patterns: []string{"^%{INT:n:int}$", "^%{WORD:w}$"},
+			line:     "hello",
+			want:     map[string]interface{}{"w": "hello"},
+		},
+		{
+			name:     "definitions override the library",
+			patterns: []string{"^%{WORD:w} %{ID:id:float}$"},
+			defs:     map[string]string{"ID": `\d+\.\d+`, "WORD": "[a-z]+"},
+			line:     "abc 1.5",
+			want:     map[string]interface{}{"w": "abc", "id": 1.5},
+		},
+		{
+			name:     "type hint that doesn't convert",
+			patterns: []string{"^%{NOTSPACE:n:int}$"},
+			line:     "many",
+			want:     map[string]interface{}{"n": "many"},
+		},
+		{
+			name:     "field names with dots",
+			patterns: []string{"%{IP:client.ip}"},
+			line:     "from 10.0.0.1",
+			want:     map[string]interface{}{"client.ip": "10.0.0.1"},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"^%{INT:n}$"},
+			line:     "hello",
+		},
+	}
+
+	for _, test := range tests {
+		parser, err := newGrokParser(test.patterns, nil, test.defs)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		got, ok := parser.parse(test.line)
+		if test.want == nil {
+			if ok {
+				t.Errorf("%s: parsed %v, want no match", test.name, got)
+			}
+		} else if !ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v %v, want %v", test.name, got, ok, test.want)
+		}
+	}
+}
+
+func TestGrokParserErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		defs     map[string]string
+	}{
+		{name: "no patterns"},
+		{name: "unknown pattern", patterns: []string{"%{NOPE:x}"}},
+		{name: "unknown type", patterns: []string{"%{INT:x:number}"}},
+		{name: "refers to itself", patterns: []string{"%{LOOP}"}, defs: map[string]string{"LOOP": "a%{LOOP}"}},
+		{name: "refer to each other", patterns: []string{"%{A}"}, defs: map[string]string{"A": "%{B}", "B": "%{A}"}},
+		{name: "invalid regexp", patterns: []string{"%{INT:x}("}},
+	}
+
+	for _, test := range tests {
+		if _, err := newGrokParser(test.patterns, nil, test.defs); err == nil {
+			t.Errorf("%s: compiled, want an error", test.name)
+		}
+	}
+}
+
+func TestGrokPatternFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-grok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	good := filepath.Join(dir, "good")
+	writeTestFile(t, good, "# comments and blank lines are skipped\n\nREQID req-%{INT}\n")
+	bad := filepath.Join(dir, "bad")
+	writeTestFile(t, bad, "REQID\n")
+
+	parser, err := newGrokParser([]string{"%{REQID:id}"}, []string{good}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := parser.parse("got req-12"); !reflect.DeepEqual(got, map[string]interface{}{"id": "req-12"}) {
+		t.Errorf("got %v", got)
+	}
+
+	if _, err := newGrokParser([]string{"%{REQID:id}"}, []string{bad}, nil); err == nil {
+		t.Error("pattern file with a name but no pattern was accepted")
+	}
+	if _, err := newGrokParser([]string{"%{REQID:id}"}, []string{filepath.Join(dir, "missing")}, nil); err == nil {
+		t.Error("missing pattern file was accepted")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// outputStatus - what an output's worker last reported about itself
+type outputStatus struct {
+	ready        bool
+	failingSince time.Time
+}
+
+// output name -> status, reset whenever an output is (re)started
+var outputHealth = struct {
+	sync.Mutex
+	outputs map[string]*outputStatus
+}{outputs: make(map[string]*outputStatus)}
+
+func resetOutputHealth(name string) {
+	outputHealth.Lock()
+	defer outputHealth.Unlock()
+
+	outputHealth.outputs[name] = &outputStatus{}
+}
+
+// outputReady - the output has initialised, e.g. its client authenticated
+func outputReady(name string) {
+	outputHealth.Lock()
+	defer outputHealth.Unlock()
+
+	if status, ok := outputHealth.outputs[name]; ok {
+		status.ready = true
+	}
+}
+
+// outputFailed - a delivery (or initialisation) attempt failed, failures only
+// count against liveness once they've continued for unhealthy_after
+func outputFailed(name string) {
+	outputHealth.Lock()
+	defer outputHealth.Unlock()
+
+	if status, ok := outputHealth.outputs[name]; ok && status.failingSince.IsZero() {
+		status.failingSince = time.Now()
+	}
+}
+
+func outputSucceeded(name string) {
+	outputHealth.Lock()
+	defer outputHealth.Unlock()
+
+	if status, ok := outputHealth.outputs[name]; ok {
+		status.failingSince = time.Time{}
+	}
+}
+
+func (config FlingMonitoring) unhealthyAfter() time.Duration {
+	if config.UnhealthyAfter == 0 {
+		return 5 * time.Minute
+	}
+
+	return time.Duration(config.UnhealthyAfter) * time.Second
+}
+
+// notReady - why fling isn't ready, nothing once every output has initialised
+func (pipe *pipeline) notReady() []string {
+	var problems []string
+
+	outputs := pipe.currentChannels()
+
+	outputHealth.Lock()
+	defer outputHealth.Unlock()
+
+	for name := range outputs {
+		if status, ok := outputHealth.outputs[name]; !ok || !status.ready {
+			problems = append(problems, fmt.Sprintf("output %s has not initialised", name))
+		}
+	}
+	sort.Strings(problems)
+
+	return problems
+}
+
+// notLive - why fling should be restarted, a worker exited without being told
+// to or an output has been failing for longer than unhealthyAfter
+func (pipe *pipeline) notLive(unhealthyAfter time.Duration) []string {
+	var problems []string
+
+	running := pipe.currentStatus()
+	for _, group := range running.groups {
+		if group.hasDied() {
+			problems = append(problems, fmt.Sprintf("%s exited unexpectedly", group.name))
+		}
+	}
+
+	outputHealth.Lock()
+	for name := range running.channels {
+		if status, ok := outputHealth.outputs[name]; ok && !status.failingSince.IsZero() {
+			if failing := time.Since(status.failingSince); failing > unhealthyAfter {
+				problems = append(problems, fmt.Sprintf("output %s has been failing for %s", name, failing.Truncate(time.Second)))
+			}
+		}
+	}
+	outputHealth.Unlock()
+	sort.Strings(problems)
+
+	return problems
+}
+
+// healthHandler - 200 when check finds nothing wrong, otherwise 503 listing every problem
+func healthHandler(check func() []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+
+		problems := check()
+		if len(problems) == 0 {
+			fmt.Fprintln(w, "ok")
+			return
+		}
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, problem := range problems {
+			fmt.Fprintln(w, problem)
+		}
+	}
+}
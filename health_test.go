@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthChecksDontWaitOnReload(t *testing.T) {
+	pipe := newPipeline()
+	pipe.apply(FlingConfig{Output: FlingOutput{Loggers: []FlingOutLogger{{Name: "out"}}}})
+	defer pipe.shutdown()
+
+	for start := time.Now(); len(pipe.notReady()) > 0 && time.Since(start) < 5*time.Second; {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// as apply does while waiting for workers to stop
+	pipe.lock.Lock()
+	checked := make(chan []string)
+	go func() {
+		checked <- append(pipe.notReady(), pipe.notLive(time.Minute)...)
+	}()
+
+	select {
+	case problems := <-checked:
+		if len(problems) != 0 {
+			t.Errorf("problems %v, want none", problems)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("health checks waited on the pipeline lock")
+	}
+	pipe.lock.Unlock()
+}
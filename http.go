@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingInHTTP - accepts events POSTed as NDJSON, a JSON array (or single object) or plain text lines
+type FlingInHTTP struct {
+	Listen       string           `json:"listen"`
+	Path         string           `json:"path,omitempty"` // default /
+	TLS          *FlingTLS        `json:"tls,omitempty"`
+	Token        string           `json:"token,omitempty"`          // required as "Authorization: Bearer <token>"
+	TokenEnv     string           `json:"token_env,omitempty"`      // or read the token from this environment variable
+	MaxBodyBytes int64            `json:"max_body_bytes,omitempty"` // after decompression, default 10MB
+	Outputs      []string         `json:"outputs"`
+	Injections   []FlingInjection `json:"injections"`
+}
+
+func (input FlingInHTTP) path() string {
+	if input.Path == "" {
+		return "/"
+	}
+
+	return input.Path
+}
+
+func (input FlingInHTTP) token() string {
+	if input.TokenEnv != "" {
+		return os.Getenv(input.TokenEnv)
+	}
+
+	return input.Token
+}
+
+// source - how the input is labelled in metrics and logs
+func (input FlingInHTTP) source() string {
+	return "http " + input.Listen + input.path()
+}
+
+func handleInHTTPs(inputs []FlingInHTTP) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input " + input.source(),
+			key:     configKey("http", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { httpInWorker(group.ctx, input, outputs) })
+			},
+		})
+	}
+
+	return configured
+}
+
+// httpInWorker - serves the input until ctx is cancelled, then lets requests already
+// being handled finish dispatching before returning
+func httpInWorker(ctx context.Context, input FlingInHTTP, outputs map[string]interface{}) {
+	listener := listenUntilDone(ctx, "tcp", input.Listen, input.TLS)
+	if listener == nil {
+		return
+	}
+
+	// handlers hold a read lock while they run, so taking the write lock waits for them all
+	var handlers sync.RWMutex
+	closed := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(input.path(), func(w http.ResponseWriter, r *http.Request) {
+		handlers.RLock()
+		defer handlers.RUnlock()
+		if closed {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		handleHTTPRequest(ctx, input, outputs, w, r)
+	})
+	server := &http.Server{Handler: mux}
+
+	stopped := make(chan bool)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if server.Shutdown(shutdownCtx) != nil {
+			server.Close()
+		}
+
+		// Shutdown gives up on requests still being handled, their events mustn't be
+		// dispatched once the outputs are closed
+		handlers.Lock()
+		closed = true
+		handlers.Unlock()
+		close(stopped)
+	}()
+
+	err := server.Serve(listener)
+	if ctx.Err() != nil {
+		<-stopped
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"listen": input.Listen,
+		"error":  err,
+	}).Error("HTTP input stopped")
+}
+
+func handleHTTPRequest(ctx context.Context, input FlingInHTTP, outputs map[string]interface{}, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is accepted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token := input.token(); token != "" {
+		given := r.Header.Get("Authorization")
+		if !strings.HasPrefix(given, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(given, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if ctx.Err() != nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// push back rather than block the client, or drop what it sent, while an output is backed up
+	for _, output := range input.Outputs {
+		if queue, ok := outputs[output].(*outputQueue); ok && queue.full() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, fmt.Sprintf("output %s is full", output), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	maxBodyBytes := input.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = 10 * 1024 * 1024
+	}
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid gzip body: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	default:
+		http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxBodyBytes+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("couldn't read body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > maxBodyBytes {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	entries, err := decodeHTTPBody(r.Header.Get("Content-Type"), data)
+	if err != nil {
+		countMetric(parseFailures, inputKey, input.source())
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	accepted := true
+	for _, logEntry := range entries {
+		countMetric(linesRead, inputKey, input.source())
+
+		logEntry["fling.source"] = "http://" + r.RemoteAddr
+		if _, ok := logEntry["@timestamp"]; !ok {
+			logEntry["@timestamp"] = get3339Time()
+		}
+		handleInjections(&logEntry, input.Injections)
+
+		accepted = dispatchEntry(FlingEvent{UniqueID: "", JSON: logEntry}, input.Outputs, outputs) && accepted
+	}
+
+	if !accepted {
+		// an output filled up part way through and its overflow policy dropped events
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "some events were dropped", http.StatusTooManyRequests)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "{\"events\":%d}\n", len(entries))
+}
+
+// decodeHTTPBody - a JSON array or object, NDJSON, or plain text with one message per line,
+// going by the content type and otherwise by what the body starts with
+func decodeHTTPBody(contentType string, data []byte) ([]map[string]interface{}, error) {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	trimmed := bytes.TrimSpace(data)
+
+	switch {
+	case mediaType == "text/plain":
+		return decodeTextLines(data), nil
+	case mediaType == "application/x-ndjson" || mediaType == "application/jsonlines":
+		return decodeNDJSON(data)
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var values []interface{}
+		if err := json.Unmarshal(trimmed, &values); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+		var entries []map[string]interface{}
+		for i, value := range values {
+			logEntry, err := httpEventEntry(value)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %v", i, err)
+			}
+			entries = append(entries, logEntry)
+		}
+		return entries, nil
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		// a single object or NDJSON sent as application/json
+		return decodeNDJSON(data)
+	case mediaType == "application/json":
+		return nil, fmt.Errorf("expected a JSON array or object")
+	default:
+		return decodeTextLines(data), nil
+	}
+}
+
+func decodeNDJSON(data []byte) ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for i := 1; ; i++ {
+		var value interface{}
+		if err := decoder.Decode(&value); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("event %d: %v", i, err)
+		}
+
+		logEntry, err := httpEventEntry(value)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %v", i, err)
+		}
+		entries = append(entries, logEntry)
+	}
+}
+
+func decodeTextLines(data []byte) []map[string]interface{} {
+	var entries []map[string]interface{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 4096), len(data)+1)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			entries = append(entries, map[string]interface{}{"message": line})
+		}
+	}
+
+	return entries
+}
+
+// httpEventEntry - objects are events as they are, strings become an event's message
+func httpEventEntry(value interface{}) (map[string]interface{}, error) {
+	switch event := value.(type) {
+	case map[string]interface{}:
+		return event, nil
+	case string:
+		return map[string]interface{}{"message": event}, nil
+	}
+
+	return nil, fmt.Errorf("expected an object or string, got %T", value)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHTTPBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []map[string]interface{}
+		err         bool
+	}{
+		{
+			name: "ndjson",
+			body: "{\"a\":1}\n\n{\"b\":\"x\"}\n",
+			want: []map[string]interface{}{{"a": 1.0}, {"b": "x"}},
+		},
+		{
+			name:        "ndjson content type",
+			contentType: "application/x-ndjson; charset=utf-8",
+			body:        "\"text\"\n{\"a\":1}",
+			want:        []map[string]interface{}{{"message": "text"}, {"a": 1.0}},
+		},
+		{
+			name:        "array",
+			contentType: "application/json",
+			body:        " [{\"a\":1}, \"text\"] ",
+			want:        []map[string]interface{}{{"a": 1.0}, {"message": "text"}},
+		},
+		{
+			name: "text",
+			body: "one\r\n\ntwo",
+			want: []map[string]interface{}{{"message": "one"}, {"message": "two"}},
+		},
+		{
+			name:        "text content type",
+			contentType: "text/plain",
+			body:        "{not json",
+			want:        []map[string]interface{}{{"message": "{not json"}},
+		},
+		{name: "empty", body: ""},
+		{name: "truncated object", body: "{\"a\":1}\n{\"b\":", err: true},
+		{name: "truncated array", body: "[{\"a\":1}", err: true},
+		{name: "array of numbers", body: "[1, 2]", err: true},
+		{name: "ndjson with a number", contentType: "application/x-ndjson", body: "{}\n3\n", err: true},
+		{name: "json that isn't an object", contentType: "application/json", body: "42", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := decodeHTTPBody(test.contentType, []byte(test.body))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: decoded %q, want an error", test.name, test.body)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHTTPBearerToken(t *testing.T) {
+	input := FlingInHTTP{Listen: ":0", Token: "secret", Outputs: []string{"out"}}
+	queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+	outputs := map[string]interface{}{"out": queue}
+
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"bearer token", "Bearer secret", http.StatusAccepted},
+		{"no header", "", http.StatusUnauthorized},
+		{"bare token", "secret", http.StatusUnauthorized},
+		{"wrong token", "Bearer secrets", http.StatusUnauthorized},
+		{"other scheme", "Basic secret", http.StatusUnauthorized},
+		{"lower case scheme", "bearer secret", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+		if test.authorization != "" {
+			request.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handleHTTPRequest(context.Background(), input, outputs, recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+	if len(queue.channel) != 1 {
+		t.Errorf("%d events dispatched, want 1", len(queue.channel))
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/tail"
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingInKubernetes - tails every container log on the node, tagging events with
+// the pod, namespace and container they came from
+type FlingInKubernetes struct {
+	Path          string           `json:"path,omitempty"` // default /var/log/containers/*.log
+	IsJSON        bool             `json:"is_json"`        // merge container output that is a JSON object into the event
+	GlobInterval  int              `json:"glob_interval"`
+	StartPosition string           `json:"start_position,omitempty"`
+	MaxLineBytes  int              `json:"max_line_bytes,omitempty"` // longer lines are truncated, default 1MB
+	Outputs       []string         `json:"outputs"`
+	Injections    []FlingInjection `json:"injections"`
+}
+
+// <pod>_<namespace>_<container>-<container id>.log, none of the names can contain an underscore
+var containerLogName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-([0-9a-f]{64})\.log$`)
+
+// <namespace>_<pod>_<pod uid>, the directory /var/log/pods/<that>/<container>/<restart>.log lives in
+var podLogDir = regexp.MustCompile(`^([^_]+)_([^_]+)_([^_]+)$`)
+
+// containerLogLine - one line as written by the container runtime
+type containerLogLine struct {
+	time    string
+	stream  string
+	partial bool // the runtime split a long line, the rest follows
+	text    string
+}
+
+func (config FlingInKubernetes) path() string {
+	if config.Path == "" {
+		return "/var/log/containers/*.log"
+	}
+
+	return config.Path
+}
+
+func (config FlingInKubernetes) maxLineBytes() int {
+	if config.MaxLineBytes == 0 {
+		return 1024 * 1024
+	}
+
+	return config.MaxLineBytes
+}
+
+func handleInKubernetes(inputs []FlingInKubernetes) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input kubernetes " + input.path(),
+			key:     configKey("kubernetes", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				file := FlingInFile{
+					Path:          input.path(),
+					IsGlob:        true,
+					GlobInterval:  input.GlobInterval,
+					StartPosition: input.StartPosition,
+					Outputs:       input.Outputs,
+				}
+				process := func(file FlingInFile) lineProcessor {
+					return containerLogProcessor(input, file.Path, outputs)
+				}
+
+				group.start(func() { fileInGlobWatcher(group, file, process) })
+			},
+		})
+	}
+
+	return configured
+}
+
+// containerMetadata - what the log file's path says about the container writing it
+func containerMetadata(path string) map[string]interface{} {
+	if match := containerLogName.FindStringSubmatch(filepath.Base(path)); match != nil {
+		return map[string]interface{}{
+			"pod":          match[1],
+			"namespace":    match[2],
+			"container":    match[3],
+			"container_id": match[4],
+		}
+	}
+
+	containerDir := filepath.Dir(path)
+	if match := podLogDir.FindStringSubmatch(filepath.Base(filepath.Dir(containerDir))); match != nil {
+		return map[string]interface{}{
+			"pod":       match[2],
+			"namespace": match[1],
+			"pod_uid":   match[3],
+			"container": filepath.Base(containerDir),
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Warn("Couldn't work out the container from log path")
+
+	return nil
+}
+
+// containerLogProcessor - reassembles the lines a runtime split and dispatches
+// one event per container log line, stdout and stderr are reassembled separately
+func containerLogProcessor(input FlingInKubernetes, path string, outputs map[string]interface{}) lineProcessor {
+	metadata := containerMetadata(path)
+	pending := make(map[string]*containerLogLine)
+	// the number of the line each pending line started on
+	started := make(map[string]int)
+	lines := 0
+
+	return func(line *tail.Line) int {
+		if line == nil {
+			// the rest of a split line never arrived, ship what there is of it
+			for stream, partial := range pending {
+				dispatchContainerLog(input, path, metadata, *partial, outputs)
+				delete(pending, stream)
+				delete(started, stream)
+			}
+			return 0
+		}
+		lines++
+
+		parsed, ok := parseContainerLogLine(line.Text)
+		if !ok {
+			log.WithFields(log.Fields{
+				"path": path,
+				"line": line.Text,
+			}).Error("Couldn't parse container log line")
+			countMetric(parseFailures, inputKey, path)
+			parsed = containerLogLine{time: get3339Time(), text: line.Text}
+		}
+
+		if partial, exists := pending[parsed.stream]; exists {
+			partial.text += parsed.text
+			partial.partial = parsed.partial
+			parsed = *partial
+		} else {
+			started[parsed.stream] = lines
+		}
+		// the rest of an overlong line is still read, so it can't keep growing what is pending
+		if len(parsed.text) > input.maxLineBytes() {
+			parsed.text = parsed.text[:input.maxLineBytes()]
+		}
+
+		if parsed.partial {
+			pending[parsed.stream] = &parsed
+		} else {
+			delete(pending, parsed.stream)
+			delete(started, parsed.stream)
+			dispatchContainerLog(input, path, metadata, parsed, outputs)
+		}
+
+		// stdout and stderr interleave, everything from the earliest line still pending is held
+		held := 0
+		for _, start := range started {
+			if lines-start+1 > held {
+				held = lines - start + 1
+			}
+		}
+		return held
+	}
+}
+
+// parseContainerLogLine - decodes both the CRI format, "<time> <stream> <P|F> <text>",
+// and docker's json-file format, {"log":"<text>\n","stream":"...","time":"..."}
+func parseContainerLogLine(text string) (containerLogLine, bool) {
+	if strings.HasPrefix(text, "{") {
+		var entry struct {
+			Log    string `json:"log"`
+			Stream string `json:"stream"`
+			Time   string `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return containerLogLine{}, false
+		}
+
+		// docker splits long lines, only the last piece ends in a newline
+		return containerLogLine{
+			time:    entry.Time,
+			stream:  entry.Stream,
+			partial: !strings.HasSuffix(entry.Log, "\n"),
+			text:    strings.TrimSuffix(entry.Log, "\n"),
+		}, true
+	}
+
+	fields := strings.SplitN(text, " ", 4)
+	if len(fields) < 3 {
+		return containerLogLine{}, false
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+		return containerLogLine{}, false
+	}
+
+	parsed := containerLogLine{time: fields[0], stream: fields[1]}
+	if len(fields) == 4 {
+		parsed.text = fields[3]
+	}
+
+	// the tag may carry more flags after a colon, P marks a partial line
+	switch strings.SplitN(fields[2], ":", 2)[0] {
+	case "P":
+		parsed.partial = true
+	case "F":
+	default:
+		return containerLogLine{}, false
+	}
+
+	return parsed, true
+}
+
+func dispatchContainerLog(input FlingInKubernetes, path string, metadata map[string]interface{}, line containerLogLine, outputs map[string]interface{}) {
+	var logEntry map[string]interface{}
+
+	if input.IsJSON && strings.HasPrefix(line.text, "{") {
+		if unmarshalErr := json.Unmarshal([]byte(line.text), &logEntry); unmarshalErr != nil {
+			log.WithFields(log.Fields{
+				"path":    path,
+				"message": line.text,
+				"error":   unmarshalErr,
+			}).Debug("Couldn't parse container output as JSON, shipping it as a message")
+			logEntry = nil
+		}
+	}
+	if logEntry == nil {
+		logEntry = make(map[string]interface{})
+		logEntry["message"] = line.text
+	}
+
+	logEntry["fling.source"] = path
+	if line.stream != "" {
+		logEntry["stream"] = line.stream
+	}
+	if metadata != nil {
+		logEntry["kubernetes"] = metadata
+	}
+
+	if _, ok := logEntry["@timestamp"]; !ok {
+		logEntry["@timestamp"] = line.time
+		if line.time == "" {
+			logEntry["@timestamp"] = get3339Time()
+		}
+	}
+
+	handleInjections(&logEntry, input.Injections)
+
+	dispatchEntry(FlingEvent{UniqueID: "", JSON: logEntry}, input.Outputs, outputs)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hpcloud/tail"
+)
+
+func TestParseContainerLogLine(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want containerLogLine
+		err  bool
+	}{
+		{
+			name: "cri",
+			text: "2024-03-01T12:00:00.123456789Z stdout F hello world",
+			want: containerLogLine{time: "2024-03-01T12:00:00.123456789Z", stream: "stdout", text: "hello world"},
+		},
+		{
+			name: "cri partial with more flags",
+			text: "2024-03-01T12:00:00Z stderr P:x part",
+			want: containerLogLine{time: "2024-03-01T12:00:00Z", stream: "stderr", partial: true, text: "part"},
+		},
+		{
+			name: "cri empty line",
+			text: "2024-03-01T12:00:00Z stdout F",
+			want: containerLogLine{time: "2024-03-01T12:00:00Z", stream: "stdout"},
+		},
+		{
+			name: "docker",
+			text: `{"log":"hello\n","stream":"stdout","time":"2024-03-01T12:00:00Z"}`,
+			want: containerLogLine{time: "2024-03-01T12:00:00Z", stream: "stdout", text: "hello"},
+		},
+		{
+			name: "docker partial",
+			text: `{"log":"hel","stream":"stderr","time":"2024-03-01T12:00:00Z"}`,
+			want: containerLogLine{time: "2024-03-01T12:00:00Z", stream: "stderr", partial: true, text: "hel"},
+		},
+		{name: "docker truncated", text: `{"log":"hel`, err: true},
+		{name: "cri bad time", text: "yesterday stdout F hello", err: true},
+		{name: "cri bad tag", text: "2024-03-01T12:00:00Z stdout X hello", err: true},
+		{name: "too few fields", text: "2024-03-01T12:00:00Z stdout", err: true},
+		{name: "plain text", text: "hello", err: true},
+	}
+
+	for _, test := range tests {
+		got, ok := parseContainerLogLine(test.text)
+		if test.err {
+			if ok {
+				t.Errorf("%s: parsed %q, want a failure", test.name, test.text)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: couldn't parse %q", test.name, test.text)
+		} else if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestContainerMetadata(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		path string
+		want map[string]interface{}
+	}{
+		{
+			"/var/log/containers/web-1_prod_nginx-" + id + ".log",
+			map[string]interface{}{"pod": "web-1", "namespace": "prod", "container": "nginx", "container_id": id},
+		},
+		{
+			"/var/log/pods/prod_web-1_0c9a/nginx/0.log",
+			map[string]interface{}{"pod": "web-1", "namespace": "prod", "pod_uid": "0c9a", "container": "nginx"},
+		},
+		{"/var/log/app.log", nil},
+	}
+
+	for _, test := range tests {
+		if got := containerMetadata(test.path); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestContainerLogProcessor(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+	input := FlingInKubernetes{Outputs: []string{"out"}}
+	process := containerLogProcessor(input, "/var/log/app.log", map[string]interface{}{"out": queue})
+
+	// stdout is split across lines with a stderr line between its pieces
+	lines := []string{
+		"2024-03-01T12:00:00Z stdout P one ",
+		"2024-03-01T12:00:01Z stderr F warning",
+		"2024-03-01T12:00:02Z stdout P two ",
+		"2024-03-01T12:00:03Z stdout F three",
+		"2024-03-01T12:00:04Z stderr P cut",
+	}
+	var held []int
+	for _, line := range lines {
+		held = append(held, process(&tail.Line{Text: line}))
+	}
+	if flushed := process(nil); flushed != 0 {
+		t.Errorf("flushing left %d lines held", flushed)
+	}
+
+	if want := []int{1, 2, 3, 0, 1}; !reflect.DeepEqual(held, want) {
+		t.Errorf("held %v, want %v", held, want)
+	}
+
+	var messages []string
+	for len(queue.channel) > 0 {
+		event := <-queue.channel
+		messages = append(messages, event.JSON["stream"].(string)+": "+event.JSON["message"].(string))
+	}
+	if want := []string{"stderr: warning", "stdout: one two three", "stderr: cut"}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("messages %q, want %q", messages, want)
+	}
+}
+
+func TestContainerLogProcessorTruncates(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+	input := FlingInKubernetes{MaxLineBytes: 8, Outputs: []string{"out"}}
+	process := containerLogProcessor(input, "/var/log/app.log", map[string]interface{}{"out": queue})
+
+	lines := []string{
+		"2024-03-01T12:00:00Z stdout P abcde",
+		"2024-03-01T12:00:01Z stdout P fghij",
+		"2024-03-01T12:00:02Z stdout P klmno",
+		"2024-03-01T12:00:03Z stdout F pq",
+		"2024-03-01T12:00:04Z stdout F short",
+	}
+	for _, line := range lines {
+		process(&tail.Line{Text: line})
+	}
+
+	var messages []string
+	for len(queue.channel) > 0 {
+		event := <-queue.channel
+		messages = append(messages, event.JSON["message"].(string))
+	}
+	if want := []string{"abcdefgh", "short"}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("messages %q, want %q", messages, want)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingTLS - certificate a listening input presents, setting a client CA makes it
+// require and verify client certificates too
+type FlingTLS struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+func (config *FlingTLS) serverConfig() (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	if config.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.ClientCAFile)
+		}
+		serverConfig.ClientCAs = pool
+		serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return serverConfig, nil
+}
+
+// retryUntilDone - calls attempt until it succeeds, backing off between failures,
+// false if ctx was cancelled first
+func retryUntilDone(ctx context.Context, fields log.Fields, message string, attempt func() error) bool {
+	retry := FlingRetry{}.withDefaults()
+
+	for i := 1; ctx.Err() == nil; i++ {
+		err := attempt()
+		if err == nil {
+			return true
+		}
+
+		backoff := retry.backoff(i)
+		log.WithFields(fields).WithFields(log.Fields{
+			"backoff": backoff.String(),
+			"error":   err,
+		}).Error(message)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+	}
+
+	return false
+}
+
+// listenUntilDone - listens on address, with TLS when tlsConfig is set, retrying in case
+// the address is still held (e.g. by the worker a reload is replacing), nil if ctx was cancelled first
+func listenUntilDone(ctx context.Context, network string, address string, tlsConfig *FlingTLS) net.Listener {
+	var listener net.Listener
+
+	fields := log.Fields{"network": network, "listen": address}
+	listened := retryUntilDone(ctx, fields, "Couldn't listen, retrying", func() error {
+		var err error
+		if tlsConfig == nil {
+			listener, err = net.Listen(network, address)
+			return err
+		}
+
+		serverConfig, err := tlsConfig.serverConfig()
+		if err != nil {
+			return err
+		}
+		listener, err = tls.Listen(network, address, serverConfig)
+		return err
+	})
+	if !listened {
+		return nil
+	}
+
+	log.WithFields(fields).Info("Listening")
+	return listener
+}
+
+// listenPacketUntilDone - listenUntilDone for datagrams
+func listenPacketUntilDone(ctx context.Context, network string, address string) net.PacketConn {
+	var conn net.PacketConn
+
+	fields := log.Fields{"network": network, "listen": address}
+	listened := retryUntilDone(ctx, fields, "Couldn't listen, retrying", func() error {
+		var err error
+		conn, err = net.ListenPacket(network, address)
+		return err
+	})
+	if !listened {
+		return nil
+	}
+
+	log.WithFields(fields).Info("Listening")
+	return conn
+}
+
+// serveConnections - handles each connection accepted on listener in its own goroutine
+// until ctx is cancelled, then closes the listener and any open connections and waits
+// for their handlers to return
+func serveConnections(ctx context.Context, listener net.Listener, handle func(conn net.Conn)) {
+	var lock sync.Mutex
+	var handlers sync.WaitGroup
+	open := make(map[net.Conn]bool)
+
+	stopped := make(chan bool)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+
+		listener.Close()
+		lock.Lock()
+		for conn := range open {
+			conn.Close()
+		}
+		lock.Unlock()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithFields(log.Fields{
+					"listen": listener.Addr().String(),
+					"error":  err,
+				}).Error("Couldn't accept connection")
+
+				if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+			}
+			break
+		}
+
+		lock.Lock()
+		if ctx.Err() != nil {
+			lock.Unlock()
+			conn.Close()
+			break
+		}
+		open[conn] = true
+		lock.Unlock()
+
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			handle(conn)
+
+			lock.Lock()
+			delete(open, conn)
+			lock.Unlock()
+			conn.Close()
+		}()
+	}
+
+	close(stopped)
+	handlers.Wait()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// logfmtParser - key=value pairs, such as level=info msg="request done" duration=12ms,
+// values may be double quoted with backslash escapes, a key without a value is true
+type logfmtParser struct {
+	pairSeparator   string // empty for any run of whitespace
+	valueSeparator  string
+	coerceNumbers   bool
+	coerceDurations bool
+}
+
+func newLogfmtParser(config *FlingParser) (lineParser, error) {
+	parser := &logfmtParser{
+		pairSeparator:   config.PairSeparator,
+		valueSeparator:  config.ValueSeparator,
+		coerceNumbers:   config.CoerceNumbers,
+		coerceDurations: config.CoerceDurations,
+	}
+	if parser.valueSeparator == "" {
+		parser.valueSeparator = "="
+	}
+
+	if strings.TrimSpace(parser.valueSeparator) == "" {
+		return nil, fmt.Errorf("logfmt parser value_separator can't be whitespace")
+	}
+	if parser.pairSeparator == parser.valueSeparator {
+		return nil, fmt.Errorf("logfmt parser pair_separator and value_separator are both %q", parser.valueSeparator)
+	}
+	if strings.Contains(parser.pairSeparator+parser.valueSeparator, `"`) {
+		return nil, fmt.Errorf("logfmt parser separators can't contain a double quote")
+	}
+
+	return parser, nil
+}
+
+// parse - the pairs in line, a line with no key=value pair in it or with an unterminated
+// quote isn't logfmt
+func (parser *logfmtParser) parse(line string) (map[string]interface{}, bool) {
+	fields := make(map[string]interface{})
+	pairs := 0
+
+	rest := line
+	for {
+		rest = parser.skipPairSeparators(rest)
+		if rest == "" {
+			break
+		}
+
+		var key string
+		key, rest = parser.scanKey(rest)
+		if !strings.HasPrefix(rest, parser.valueSeparator) {
+			if key != "" {
+				fields[key] = true
+			}
+			continue
+		}
+		rest = rest[len(parser.valueSeparator):]
+
+		var value string
+		var quoted, ok bool
+		if value, quoted, rest, ok = parser.scanValue(rest); !ok {
+			return nil, false
+		}
+		if key == "" {
+			continue
+		}
+
+		pairs++
+		if quoted {
+			fields[key] = value
+		} else {
+			fields[key] = parser.coerce(value)
+		}
+	}
+
+	return fields, pairs > 0
+}
+
+// skipPairSeparators - rest without the separators (and whitespace) in front of the next pair
+func (parser *logfmtParser) skipPairSeparators(rest string) string {
+	for {
+		trimmed := rest
+		if parser.pairSeparator != "" {
+			trimmed = strings.TrimPrefix(trimmed, parser.pairSeparator)
+		}
+		trimmed = strings.TrimLeftFunc(trimmed, unicode.IsSpace)
+		if trimmed == rest {
+			return rest
+		}
+		rest = trimmed
+	}
+}
+
+// scanKey - the key at the start of rest, up to its value separator or the end of the pair
+func (parser *logfmtParser) scanKey(rest string) (string, string) {
+	end := len(rest)
+	if i := strings.Index(rest, parser.valueSeparator); i >= 0 {
+		end = i
+	}
+	if i := parser.pairEnd(rest[:end]); i >= 0 {
+		end = i
+	}
+
+	return strings.TrimSpace(rest[:end]), rest[end:]
+}
+
+// scanValue - the value at the start of rest, unescaped if it is quoted, false if its
+// closing quote is missing
+func (parser *logfmtParser) scanValue(rest string) (string, bool, string, bool) {
+	// whitespace only ends the pair when it is the pair separator, otherwise a: "b" is quoted
+	if parser.pairSeparator != "" {
+		rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	}
+
+	if !strings.HasPrefix(rest, `"`) {
+		end := parser.pairEnd(rest)
+		if end < 0 {
+			end = len(rest)
+		}
+		return strings.TrimSpace(rest[:end]), false, rest[end:], true
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(rest); i++ {
+		switch rest[i] {
+		case '"':
+			return value.String(), true, rest[i+1:], true
+		case '\\':
+			if i+1 == len(rest) {
+				return "", false, "", false
+			}
+			i++
+			switch rest[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			default:
+				// \" and \\, anything else is kept as the character escaped
+				value.WriteByte(rest[i])
+			}
+		default:
+			value.WriteByte(rest[i])
+		}
+	}
+
+	return "", false, "", false
+}
+
+// pairEnd - where the pair at the start of text ends, -1 if it runs to the end
+func (parser *logfmtParser) pairEnd(text string) int {
+	if parser.pairSeparator == "" {
+		return strings.IndexFunc(text, unicode.IsSpace)
+	}
+
+	return strings.Index(text, parser.pairSeparator)
+}
+
+// coerce - an unquoted value as a number, or a duration as float seconds, when asked to
+func (parser *logfmtParser) coerce(value string) interface{} {
+	if parser.coerceNumbers && looksNumeric(value) {
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return number
+		}
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	}
+	if parser.coerceDurations && looksNumeric(value) {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration.Seconds()
+		}
+	}
+
+	return value
+}
+
+// looksNumeric - whether value starts like a number, keeping ParseFloat from turning
+// words such as inf and nan into numbers
+func looksNumeric(value string) bool {
+	value = strings.TrimLeft(value, "+-")
+
+	return value != "" && (value[0] == '.' || (value[0] >= '0' && value[0] <= '9'))
+}
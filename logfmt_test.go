@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLogfmtParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *FlingParser
+		line   string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "pairs",
+			config: &FlingParser{},
+			line:   `level=info msg="request done" duration=12ms`,
+			want:   map[string]interface{}{"level": "info", "msg": "request done", "duration": "12ms"},
+		},
+		{
+			name:   "flags and empty values",
+			config: &FlingParser{},
+			line:   "  debug a= b=1\tc ",
+			want:   map[string]interface{}{"debug": true, "a": "", "b": "1", "c": true},
+		},
+		{
+			name:   "escapes",
+			config: &FlingParser{},
+			line:   `msg="say \"hi\"\n\tto C:\\ and \q"`,
+			want:   map[string]interface{}{"msg": "say \"hi\"\n\tto C:\\ and q"},
+		},
+		{
+			name:   "numbers",
+			config: &FlingParser{CoerceNumbers: true},
+			line:   `n=42 neg=-7 f=1.5 quoted="42" inf=inf nan=NaN big=1e400 ms=12ms`,
+			want: map[string]interface{}{
+				"n": int64(42), "neg": int64(-7), "f": 1.5, "quoted": "42", "inf": "inf", "nan": "NaN", "big": "1e400", "ms": "12ms",
+			},
+		},
+		{
+			name:   "durations",
+			config: &FlingParser{CoerceDurations: true},
+			line:   `a=12ms b=1h30m c="5s" d=5 e=soon`,
+			want:   map[string]interface{}{"a": 0.012, "b": 5400.0, "c": "5s", "d": "5", "e": "soon"},
+		},
+		{
+			name:   "separators",
+			config: &FlingParser{PairSeparator: ",", ValueSeparator: ":"},
+			line:   `a: 1, b: "x, y",,c:two words`,
+			want:   map[string]interface{}{"a": "1", "b": "x, y", "c": "two words"},
+		},
+		{
+			name:   "empty key",
+			config: &FlingParser{},
+			line:   `=x a=1`,
+			want:   map[string]interface{}{"a": "1"},
+		},
+		{name: "no pairs", config: &FlingParser{}, line: "hello world"},
+		{name: "empty", config: &FlingParser{}, line: ""},
+		{name: "unterminated quote", config: &FlingParser{}, line: `a=1 msg="oops`},
+		{name: "trailing backslash", config: &FlingParser{}, line: `msg="oops\`},
+	}
+
+	for _, test := range tests {
+		parser, err := newLogfmtParser(test.config)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		got, ok := parser.parse(test.line)
+		if test.want == nil {
+			if ok {
+				t.Errorf("%s: parsed %v, want no match", test.name, got)
+			}
+		} else if !ok || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v %v, want %v", test.name, got, ok, test.want)
+		}
+	}
+}
+
+func TestNewLogfmtParserErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *FlingParser
+	}{
+		{"whitespace value separator", &FlingParser{ValueSeparator: " "}},
+		{"same separators", &FlingParser{PairSeparator: ":", ValueSeparator: ":"}},
+		{"default value separator as the pair separator", &FlingParser{PairSeparator: "="}},
+		{"quote in a separator", &FlingParser{PairSeparator: `"`}},
+	}
+
+	for _, test := range tests {
+		if _, err := newLogfmtParser(test.config); err == nil {
+			t.Errorf("%s: created, want an error", test.name)
+		}
+	}
+}
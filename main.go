@@ -10,6 +10,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
@@ -20,18 +22,19 @@ import (
 )
 
 var (
-	configFile  = kingpin.Flag("config", "Configuration file").Required().Short('c').String()
-	debugFlag   = kingpin.Flag("debug", "Enable Debug Logging").Short('d').Bool()
-	inotifyFlag = kingpin.Flag("inotify", "Enable iNotify file monitoring").Short('i').Bool()
-	version     = "master" //overridden by build system, master as default
+	runCommand      = kingpin.Command("run", "Ship logs (default)").Default()
+	validateCommand = kingpin.Command("validate", "Check a configuration file for problems and exit")
+	configFile      = kingpin.Flag("config", "Configuration file").Required().Short('c').String()
+	debugFlag       = kingpin.Flag("debug", "Enable Debug Logging").Short('d').Bool()
+	inotifyFlag     = kingpin.Flag("inotify", "Enable iNotify file monitoring").Short('i').Bool()
+	watchConfigFlag = kingpin.Flag("watch-config", "Reload the configuration when the file changes").Short('w').Bool()
+	version         = "master" //overridden by build system, master as default
 )
 
 /*
 Todo:
-- See if I can get hpcloud/tail to use logrus?
 - lots of error handling
 - config param defaults
-- add signal handler support
 */
 
 //FlingEvent - struct to hold events and any further tracking fields needed
@@ -43,16 +46,28 @@ type FlingEvent struct {
 
 //FlingConfig - top level structure of json config file
 type FlingConfig struct {
-	Input     FlingInput      `json:"input"`
-	Files     []FlingInFile   `json:"files"`
-	Rotations []FlingRotation `json:"rotations"`
-	Output    FlingOutput     `json:"output"`
+	Input      FlingInput      `json:"input"`
+	Files      []FlingInFile   `json:"files"`
+	Rotations  []FlingRotation `json:"rotations"`
+	Output     FlingOutput     `json:"output"`
+	Registry   FlingRegistry   `json:"registry"`
+	Monitoring FlingMonitoring `json:"monitoring"`
+	// seconds allowed for outputs to drain after SIGTERM/SIGINT
+	ShutdownTimeout int `json:"shutdown_timeout,omitempty"`
 }
 
 //FlingInput - map of input type arrays
 type FlingInput struct {
-	PubSubs []FlingInPubSub `json:"pubsub"`
-	Files   []FlingInFile   `json:"files"`
+	PubSubs    []FlingInPubSub     `json:"pubsub"`
+	Files      []FlingInFile       `json:"files"`
+	Kubernetes []FlingInKubernetes `json:"kubernetes"`
+	Syslogs    []FlingInSyslog     `json:"syslog"`
+	Execs      []FlingInExec       `json:"exec"`
+	HTTPs      []FlingInHTTP       `json:"http"`
+	Sockets    []FlingInSocket     `json:"socket"`
+	Forwards   []FlingInForward    `json:"forward"`
+	OTLPs      []FlingInOTLP       `json:"otlp"`
+	GELFs      []FlingInGELF       `json:"gelf"`
 }
 
 //FlingInPubSub - pub/sub input type
@@ -62,6 +77,12 @@ type FlingInPubSub struct {
 	Subscription string           `json:"subscription"`
 	Outputs      []string         `json:"outputs"`
 	Injections   []FlingInjection `json:"injections"`
+
+	// flow control, zero values keep the pubsub client defaults
+	MaxOutstandingMessages int `json:"max_outstanding_messages,omitempty"`
+	MaxOutstandingBytes    int `json:"max_outstanding_bytes,omitempty"`
+	NumGoroutines          int `json:"num_goroutines,omitempty"`
+	MaxExtension           int `json:"max_extension,omitempty"` // seconds
 }
 
 // FlingRotation - sets of files to rotate and the commands to run afterwards
@@ -85,6 +106,7 @@ type FlingOutBigQuery struct {
 	ProjectID    string `json:"project_id"`
 	BatchSize    int    `json:"batch_size,omitempty"`
 	BatchTimeout int    `json:"batch_timeout,omitempty"`
+	FlingOutputQueue
 }
 
 //FlingOutElastic - Elastic output config
@@ -93,6 +115,7 @@ type FlingOutElastic struct {
 	Index    string               `json:"index_pattern"`
 	Hosts    []string             `json:"hosts"`
 	Template FlingElasticTemplate `json:"template"`
+	FlingOutputQueue
 }
 
 //FlingElasticTemplate - information on managing an elasticsearch indexing template
@@ -109,6 +132,18 @@ type FlingOutPubSub struct {
 	Project  string `json:"project"`
 	Topic    string `json:"topic"`
 	AuthFile string `json:"auth_file"`
+	FlingOutputQueue
+
+	Retry      FlingRetry       `json:"retry"`
+	DeadLetter *FlingDeadLetter `json:"dead_letter,omitempty"`
+
+	// batching, zero values keep the pubsub client defaults
+	CountThreshold int `json:"count_threshold,omitempty"`
+	ByteThreshold  int `json:"byte_threshold,omitempty"`
+	DelayThreshold int `json:"delay_threshold_ms,omitempty"`
+	NumGoroutines  int `json:"num_goroutines,omitempty"`
+	PublishTimeout int `json:"publish_timeout,omitempty"` // seconds
+	MaxOutstanding int `json:"max_outstanding,omitempty"` // publishes awaiting a result, default 1000
 }
 
 //FlingOutLogger - Send messages to the logger library
@@ -116,16 +151,22 @@ type FlingOutPubSub struct {
 type FlingOutLogger struct {
 	Name      string `json:"name"`
 	IsEnabled bool   `json:"is_enabled"`
+	FlingOutputQueue
 }
 
 //FlingInFile - instance of a file to monitor
 type FlingInFile struct {
-	Path         string           `json:"path"`
-	IsJSON       bool             `json:"is_json"`
-	IsGlob       bool             `json:"is_glob"`
-	GlobInterval int              `json:"glob_interval"`
-	Outputs      []string         `json:"outputs"`
-	Injections   []FlingInjection `json:"injections"`
+	Path          string           `json:"path"`
+	IsJSON        bool             `json:"is_json"`
+	IsGlob        bool             `json:"is_glob"`
+	GlobInterval  int              `json:"glob_interval"`
+	Exclude       []string         `json:"exclude,omitempty"`        // globs of matching files not to tail, ** is supported
+	IgnoreOlder   int              `json:"ignore_older,omitempty"`   // seconds, files matched by a glob that haven't been written to since are skipped
+	StartPosition string           `json:"start_position,omitempty"` // "end" (default) or "beginning" when no offset is known
+	Multiline     *FlingMultiline  `json:"multiline,omitempty"`
+	Parser        *FlingParser     `json:"parser,omitempty"` // for files that aren't JSON
+	Outputs       []string         `json:"outputs"`
+	Injections    []FlingInjection `json:"injections"`
 }
 
 //FlingInjection - fields to add to the log line
@@ -143,11 +184,15 @@ func init() {
 }
 
 func main() {
-	log.Info("Initalizing")
 	//Parse command line params
 	kingpin.Version(version)
-	kingpin.Parse()
+	command := kingpin.Parse()
 
+	if command == validateCommand.FullCommand() {
+		os.Exit(validateConfigFile(*configFile))
+	}
+
+	log.Info("Initalizing")
 	if *debugFlag {
 		log.SetLevel(log.DebugLevel)
 	}
@@ -160,20 +205,51 @@ func main() {
 		os.Exit(-1)
 	}
 
-	//var outputs map[string]interface{}
-	//start up go routines for any outputs
-	outputChannels := handleOutputs(config.Output)
+	registry, err = loadRegistry(config.Registry)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  config.Registry.Path,
+			"error": err,
+		}).Fatal("Couldn't load registry")
+		os.Exit(-1)
+	}
+	go registryWorker(registry, config.Registry)
+
+	//start up go routines for outputs, rotations and the inputs feeding the outputs
+	pipe := newPipeline()
+	pipe.apply(config)
+	go reportDrops(pipe)
+	if config.Monitoring.Listen != "" {
+		go serveMonitoring(config.Monitoring, pipe)
+	}
 
-	//start up go routines for any rotations requested
-	handleRotations(config.Rotations)
+	//reload on SIGHUP until told to stop
+	if !run(pipe, *configFile, *watchConfigFlag) {
+		os.Exit(1)
+	}
+}
+
+// loadConfig - reads and validates the config, reporting every problem found
+func loadConfig(path string) (FlingConfig, error) {
+	config, err := readConfig(path)
+	if err != nil {
+		return config, err
+	}
 
-	//setup handlers for all of the files to be watched
-	handleInputs(config.Input, outputChannels)
+	problems, warnings := validateConfig(config)
+	for _, warning := range warnings {
+		log.WithFields(log.Fields{
+			"path": path,
+		}).Warn(warning)
+	}
+	if len(problems) > 0 {
+		return config, problems
+	}
 
-	select {} //Take a big nap FIXME: add signal handlers down the road
+	return config, nil
 }
 
-func loadConfig(path string) (FlingConfig, error) {
+func readConfig(path string) (FlingConfig, error) {
 	var config FlingConfig
 	configString, readError := ioutil.ReadFile(path)
 
@@ -186,38 +262,38 @@ func loadConfig(path string) (FlingConfig, error) {
 	return config, parseError
 }
 
-func handleInputs(input FlingInput, outputChannels map[string]interface{}) {
-	handleInFiles(input.Files, outputChannels)
-	//handleInPubSub(input.PubSubs, outputChannels)
+func handleInputs(input FlingInput) []configuredWorker {
+	var inputs []configuredWorker
+
+	inputs = append(inputs, handleInFiles(input.Files)...)
+	inputs = append(inputs, handleInPubSubs(input.PubSubs)...)
+	inputs = append(inputs, handleInKubernetes(input.Kubernetes)...)
+	inputs = append(inputs, handleInSyslogs(input.Syslogs)...)
+	inputs = append(inputs, handleInExecs(input.Execs)...)
+	inputs = append(inputs, handleInHTTPs(input.HTTPs)...)
+	inputs = append(inputs, handleInSockets(input.Sockets)...)
+	inputs = append(inputs, handleInForwards(input.Forwards)...)
+	inputs = append(inputs, handleInOTLPs(input.OTLPs)...)
+	inputs = append(inputs, handleInGELFs(input.GELFs)...)
+
+	return inputs
 }
 
-func handleOutputs(outputs FlingOutput) map[string]interface{} {
-	var channels map[string]interface{}
-	channels = make(map[string]interface{})
-
-	for k, v := range handleOutPubSubs(outputs.PubSubs) {
-		channels[k] = v
-	}
+func handleOutputs(outputs FlingOutput) []configuredOutput {
+	var configured []configuredOutput
 
-	for k, v := range handleOutLoggers(outputs.Loggers) {
-		channels[k] = v
-	}
+	configured = append(configured, handleOutPubSubs(outputs.PubSubs)...)
+	configured = append(configured, handleOutLoggers(outputs.Loggers)...)
 	/*
-		for k, v := range handleOutElastics(outputs.Elastics) {
-			channels[k] = v
-		}
-
-		for k, v := range handleOutBigQuery(outputs.BigQueries) {
-			channels[k] = v
-		}
+		configured = append(configured, handleOutElastics(outputs.Elastics)...)
+		configured = append(configured, handleOutBigQuery(outputs.BigQueries)...)
 	*/
 
-	return channels
+	return configured
 }
 
-func handleOutBigQuery(outputs []FlingOutBigQuery) map[string]interface{} {
-	var channels map[string]interface{}
-	channels = make(map[string]interface{})
+func handleOutBigQuery(outputs []FlingOutBigQuery) []configuredOutput {
+	var configured []configuredOutput
 
 	for _, output := range outputs {
 		if output.BatchSize == 0 {
@@ -233,11 +309,16 @@ func handleOutBigQuery(outputs []FlingOutBigQuery) map[string]interface{} {
 			output.BatchTimeout = 30
 		}
 		//FIXME add error checking to projectID etc...
-		channels[output.Name] = make(chan FlingEvent, 1000)
-		go outputBigQueryWorker(output, channels[output.Name].(chan FlingEvent))
+		output := output
+		configured = append(configured, configuredOutput{
+			name:   output.Name,
+			key:    configKey("bigquery", output),
+			queue:  output.FlingOutputQueue,
+			worker: func(_ context.Context, channel chan FlingEvent) { outputBigQueryWorker(output, channel) },
+		})
 	}
 
-	return channels
+	return configured
 }
 
 func outputBigQueryWorker(output FlingOutBigQuery, channel chan FlingEvent) {
@@ -261,7 +342,19 @@ func outputBigQueryWorker(output FlingOutBigQuery, channel chan FlingEvent) {
 
 	for {
 		select {
-		case event := <-channel:
+		case event, ok := <-channel:
+			if !ok {
+				//channel closed for shutdown, whatever is batched goes now
+				log.WithFields(log.Fields{
+					"OutputName": output.Name,
+					"count":      len(batch),
+				}).Debug("flushing final batch")
+
+				//FIXME: Add the code to actually send stuff to BQ
+
+				return
+			}
+
 			log.WithFields(log.Fields{
 				"OutputName": output.Name,
 				"UniqueID":   event.UniqueID,
@@ -296,116 +389,244 @@ func outputBigQueryWorker(output FlingOutBigQuery, channel chan FlingEvent) {
 	}
 }
 
-func handleOutLoggers(outputs []FlingOutLogger) map[string]interface{} {
-	var channels map[string]interface{}
-	channels = make(map[string]interface{})
+func handleOutLoggers(outputs []FlingOutLogger) []configuredOutput {
+	var configured []configuredOutput
 
 	for _, output := range outputs {
-		channels[output.Name] = make(chan FlingEvent, 1000)
-		go outputLoggerWorker(output.Name, output.IsEnabled, channels[output.Name].(chan FlingEvent))
+		output := output
+		configured = append(configured, configuredOutput{
+			name:  output.Name,
+			key:   configKey("logger", output),
+			queue: output.FlingOutputQueue,
+			worker: func(_ context.Context, channel chan FlingEvent) {
+				outputLoggerWorker(output.Name, output.IsEnabled, channel)
+			},
+		})
 	}
 
-	return channels
+	return configured
 }
 
 func outputLoggerWorker(name string, isEnabled bool, channel chan FlingEvent) {
-	for {
-		event := <-channel
+	outputReady(name)
 
+	for event := range channel {
 		if isEnabled {
 			log.WithFields(log.Fields{
 				"OutputName": name,
 				"UniqueID":   event.UniqueID,
 			}).Debug(fmt.Sprintf("%s", event.JSON))
 		}
+		countMetric(eventsPublished, outputKey, name)
 	}
 }
 
-func handleOutPubSubs(outputs []FlingOutPubSub) map[string]interface{} {
-	var channels map[string]interface{}
-	channels = make(map[string]interface{})
+func handleOutPubSubs(outputs []FlingOutPubSub) []configuredOutput {
+	var configured []configuredOutput
 
 	for _, output := range outputs {
-		channels[output.Name] = make(chan FlingEvent, 1000)
-		go pubSubOutWorker(output.Project, output.Topic, output.AuthFile, channels[output.Name].(chan FlingEvent))
+		output := output
+		configured = append(configured, configuredOutput{
+			name:   output.Name,
+			key:    configKey("pubsub", output),
+			queue:  output.FlingOutputQueue,
+			worker: func(ctx context.Context, channel chan FlingEvent) { pubSubOutWorker(ctx, output, channel) },
+		})
 	}
 
-	return channels
+	return configured
 }
 
-func pubSubOutWorker(project string, topicName string, authfile string, channel chan FlingEvent) {
+// pubSubOutWorker - publishes channel to the output's topic, stop only cancels waiting
+// for the client to be created
+func pubSubOutWorker(stop context.Context, output FlingOutPubSub, channel chan FlingEvent) {
+	//deliberately not tied to shutdown, publishing continues while the channel drains
 	ctx := context.Background()
 
-	if project == "" {
-		log.Fatal("PubSub output project must be defined")
-		panic("Invalid Config")
+	retry := output.Retry.withDefaults()
+
+	//events queue up (and readiness fails) until the client can be created
+	var pubSubClient *pubsub.Client
+	for attempt := 1; ; attempt++ {
+		var err error
+		pubSubClient, err = newPubSubClient(ctx, output.Project, output.AuthFile)
+		if err == nil {
+			break
+		}
+
+		outputFailed(output.Name)
+		backoff := retry.backoff(attempt)
+		log.WithFields(log.Fields{
+			"project":   output.Project,
+			"topic":     output.Topic,
+			"auth_file": output.AuthFile,
+			"backoff":   backoff.String(),
+		}).Error(fmt.Sprintf("Could not create pubsub Client: %v", err))
+
+		select {
+		case <-time.After(backoff):
+		case <-stop.Done():
+			log.WithFields(log.Fields{"OutputName": output.Name}).Error("Output stopped before its pubsub Client could be created, dropping queued events")
+			return
+		}
 	}
-	if topicName == "" {
-		log.Fatal("PubSub output topic must be defined")
-		panic("Invalid Config")
+	outputSucceeded(output.Name)
+	outputReady(output.Name)
+
+	topic := pubSubClient.Topic(output.Topic)
+	topic.PublishSettings = pubSubPublishSettings(output)
+
+	if output.MaxOutstanding == 0 {
+		output.MaxOutstanding = 1000
+	}
+	publisher := &pubSubPublisher{
+		name:        output.Name,
+		topic:       topic,
+		outstanding: make(chan bool, output.MaxOutstanding),
+		retry:       retry,
+		deadLetter:  newDeadLetterSink(output.Name, output.DeadLetter),
 	}
+	defer publisher.stop()
 
-	var pubSubClient *pubsub.Client
-	var err error
+	//send hello message to topic to keep track of what clients, versions etc.. are sending in data
+	// Published directly rather than queued so it can't race inputs filling (or shutdown closing) the channel
+	publisher.publish(ctx, createPubSubInitMsg(output.Topic))
 
-	if authfile == "" {
-		pubSubClient, err = pubsub.NewClient(ctx, project)
-	} else {
-		pubSubClient, err = pubsub.NewClient(ctx, project, option.WithServiceAccountFile(authfile))
+	for event := range channel {
+		publisher.publish(ctx, event)
 	}
+}
 
-	if err != nil {
+func newPubSubClient(ctx context.Context, project string, authFile string) (*pubsub.Client, error) {
+	if authFile == "" {
+		return pubsub.NewClient(ctx, project)
+	}
+
+	return pubsub.NewClient(ctx, project, option.WithServiceAccountFile(authFile))
+}
+
+func pubSubPublishSettings(output FlingOutPubSub) pubsub.PublishSettings {
+	settings := pubsub.DefaultPublishSettings
+
+	if output.CountThreshold > 0 {
+		settings.CountThreshold = output.CountThreshold
+	}
+	if output.ByteThreshold > 0 {
+		settings.ByteThreshold = output.ByteThreshold
+	}
+	if output.DelayThreshold > 0 {
+		settings.DelayThreshold = time.Duration(output.DelayThreshold) * time.Millisecond
+	}
+	if output.NumGoroutines > 0 {
+		settings.NumGoroutines = output.NumGoroutines
+	}
+	if output.PublishTimeout > 0 {
+		settings.Timeout = time.Duration(output.PublishTimeout) * time.Second
+	}
+
+	return settings
+}
+
+// pubSubPublisher - keeps up to cap(outstanding) publishes in flight, the
+// pubsub client batches them and results are collected in the background
+type pubSubPublisher struct {
+	name        string
+	topic       *pubsub.Topic
+	outstanding chan bool
+	results     sync.WaitGroup
+	retry       FlingRetry
+	deadLetter  *deadLetterSink
+}
+
+func (publisher *pubSubPublisher) publish(ctx context.Context, event FlingEvent) {
+	message, marshalErr := json.Marshal(event.JSON)
+	if marshalErr != nil {
 		log.WithFields(log.Fields{
-			"project":   project,
-			"topic":     topicName,
-			"auth_file": authfile,
-		}).Fatal(fmt.Sprintf("Could not create pubsub Client: %v", err))
-		panic("Couldn't authenticate to pubsub")
+			"OutputName": publisher.name,
+			"error":      marshalErr,
+		}).Error("Event Marshalling for pub/sub submission failed")
+		publisher.deadLetter.send(event, 0, marshalErr)
+		return
 	}
 
-	topic := pubSubClient.Topic(topicName)
-	defer topic.Stop()
+	//blocks once max_outstanding publishes are waiting on pub/sub, including retries
+	publisher.outstanding <- true
+	publisher.results.Add(1)
 
-	//send hello message to topic to keep track of what clients, versions etc.. are sending in data
-	// Run this in a go routine to avoid a race condition with inputs filling the channel
-	go createPubSubInitMsg(topicName, channel)
+	started := time.Now()
+	result := publisher.topic.Publish(ctx, &pubsub.Message{
+		Data: message,
+	})
 
-	for {
-		event := <-channel
+	go publisher.awaitResult(ctx, event, message, result, started)
+}
 
-		message, marshalErr := json.Marshal(event.JSON)
-		if marshalErr != nil {
-			log.Error("Event Marshalling for pub/sub submission failed")
+func (publisher *pubSubPublisher) awaitResult(ctx context.Context, event FlingEvent, message []byte, result *pubsub.PublishResult, started time.Time) {
+	defer publisher.results.Done()
+	defer func() { <-publisher.outstanding }()
+
+	for attempt := 1; ; attempt++ {
+		id, err := result.Get(ctx)
+		if err == nil {
+			countMetric(eventsPublished, outputKey, publisher.name)
+			recordPublishLatency(publisher.name, started)
+			outputSucceeded(publisher.name)
+			log.WithFields(log.Fields{
+				"OutputName": publisher.name,
+				"id":         id,
+				"latency":    time.Since(started).String(),
+			}).Debug("Published Message")
 			return
 		}
-		result := topic.Publish(ctx, &pubsub.Message{
-			Data: message,
-		})
+		countMetric(publishErrors, outputKey, publisher.name)
+		outputFailed(publisher.name)
 
-		id, err := result.Get(ctx)
-		if err != nil {
+		if !isTransientPublishError(err) || attempt >= publisher.retry.MaxAttempts {
 			log.WithFields(log.Fields{
-				"error": err,
+				"OutputName": publisher.name,
+				"attempts":   attempt,
+				"error":      err,
 			}).Error("Failed to publish message")
-		} else {
-			log.WithFields(log.Fields{
-				"id": id,
-			}).Debug("Published Message")
+			publisher.deadLetter.send(event, attempt, err)
+			return
 		}
 
+		backoff := publisher.retry.backoff(attempt)
+		log.WithFields(log.Fields{
+			"OutputName": publisher.name,
+			"attempt":    attempt,
+			"backoff":    backoff.String(),
+			"error":      err,
+		}).Warn("Failed to publish message, retrying")
+
+		time.Sleep(backoff)
+		result = publisher.topic.Publish(ctx, &pubsub.Message{
+			Data: message,
+		})
 	}
 }
 
-func handleOutElastics(outputs []FlingOutElastic) map[string]interface{} {
-	var channels map[string]interface{}
-	channels = make(map[string]interface{})
+// stop - waits for every result, retries included, then shuts the topic down
+func (publisher *pubSubPublisher) stop() {
+	publisher.results.Wait()
+	publisher.topic.Stop()
+	publisher.deadLetter.close()
+}
+
+func handleOutElastics(outputs []FlingOutElastic) []configuredOutput {
+	var configured []configuredOutput
 
 	for _, output := range outputs {
-		channels[output.Name] = make(chan FlingEvent, 1000)
-		go elasticOutWorker(output, channels[output.Name].(chan FlingEvent))
+		output := output
+		configured = append(configured, configuredOutput{
+			name:   output.Name,
+			key:    configKey("elasticsearch", output),
+			queue:  output.FlingOutputQueue,
+			worker: func(_ context.Context, channel chan FlingEvent) { elasticOutWorker(output, channel) },
+		})
 	}
 
-	return channels
+	return configured
 }
 
 func elasticOutWorker(config FlingOutElastic, channel chan FlingEvent) {
@@ -423,7 +644,7 @@ func handleElasticTemplate(config FlingOutElastic) {
 
 }
 
-func createPubSubInitMsg(topicName string, channel chan FlingEvent) {
+func createPubSubInitMsg(topicName string) FlingEvent {
 	var logEntry map[string]interface{}
 	logEntry = make(map[string]interface{})
 	hostname, _ := os.Hostname()
@@ -434,59 +655,208 @@ func createPubSubInitMsg(topicName string, channel chan FlingEvent) {
 	logEntry["@timestamp"] = get3339Time()
 	logEntry["message"] = "Starting up Fling PubSub Output"
 
-	channel <- FlingEvent{JSON: logEntry}
 	log.WithFields(log.Fields{"topic": topicName}).Info("PubSub Init message queued")
+
+	return FlingEvent{JSON: logEntry}
 }
 
-func handleInFiles(files []FlingInFile, outputs map[string]interface{}) {
-	for _, file := range files {
-		if file.IsGlob {
-			go fileInGlobWatcher(file, outputs)
+func handleInFiles(files []FlingInFile) []configuredWorker {
+	var inputs []configuredWorker
 
-		} else {
-			startFileWorker(file, outputs)
-		}
+	for _, file := range files {
+		file := file
+		inputs = append(inputs, configuredWorker{
+			name:    "input file " + file.Path,
+			key:     configKey("file", file),
+			outputs: file.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				process := func(file FlingInFile) lineProcessor {
+					if file.Multiline != nil {
+						return multilineProcessor(file, func(text string) {
+							processInFileLine(text, nil, file, outputs)
+						})
+					}
+
+					return func(line *tail.Line) int {
+						if line != nil {
+							processInFileLine(line.Text, nil, file, outputs)
+						}
+						return 0
+					}
+				}
+
+				if file.IsGlob {
+					group.start(func() { fileInGlobWatcher(group, file, process) })
+
+				} else {
+					startFileWorker(group, file, process)
+				}
+			},
+		})
 	}
+
+	return inputs
 }
 
-func fileInGlobWatcher(file FlingInFile, outputs map[string]interface{}) {
-	if file.GlobInterval == 0 {
-		file.GlobInterval = 30
+func handleInPubSubs(subs []FlingInPubSub) []configuredWorker {
+	var inputs []configuredWorker
+
+	for _, sub := range subs {
+		sub := sub
+		inputs = append(inputs, configuredWorker{
+			name:    "input pubsub " + sub.Subscription,
+			key:     configKey("pubsub", sub),
+			outputs: sub.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { pubSubInWorker(group.ctx, sub, outputs) })
+			},
+		})
 	}
-	watchedPaths := make(map[string]bool)
-	var globPattern = file.Path
 
-	for {
-		log.WithFields(log.Fields{
-			"path": globPattern,
-		}).Debug("Checking for new files in glob")
+	return inputs
+}
+
+// pubSubInWorker - receives from the subscription until ctx is cancelled, reconnecting
+// with backoff if the client can't be created or receiving fails
+func pubSubInWorker(ctx context.Context, sub FlingInPubSub, outputs map[string]interface{}) {
+	retry := FlingRetry{}.withDefaults()
 
-		paths, _ := filepath.Glob(globPattern)
-		for _, path := range paths {
-			file.Path = path
+	for attempt := 1; ctx.Err() == nil; attempt++ {
+		pubSubClient, err := newPubSubClient(ctx, sub.Project, sub.AuthFile)
+		if err == nil {
+			subscription := pubSubClient.Subscription(sub.Subscription)
+			subscription.ReceiveSettings = pubSubReceiveSettings(sub)
 
-			if _, exists := watchedPaths[file.Path]; !exists {
-				watchedPaths[file.Path] = true
-				startFileWorker(file, outputs)
+			log.WithFields(log.Fields{
+				"project":      sub.Project,
+				"subscription": sub.Subscription,
+			}).Info("Receiving from subscription")
+
+			err = subscription.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+				processInPubSubMessage(ctx, msg, sub, outputs)
+			})
+			pubSubClient.Close()
+
+			if err == nil {
+				attempt = 0
+				continue
 			}
 		}
 
-		time.Sleep(time.Duration(file.GlobInterval) * time.Second)
+		backoff := retry.backoff(attempt)
+		log.WithFields(log.Fields{
+			"project":      sub.Project,
+			"subscription": sub.Subscription,
+			"auth_file":    sub.AuthFile,
+			"backoff":      backoff.String(),
+			"error":        err,
+		}).Error("Couldn't receive from subscription, retrying")
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+	}
+}
+
+func pubSubReceiveSettings(sub FlingInPubSub) pubsub.ReceiveSettings {
+	settings := pubsub.DefaultReceiveSettings
+
+	if sub.MaxOutstandingMessages != 0 {
+		settings.MaxOutstandingMessages = sub.MaxOutstandingMessages
+	}
+	if sub.MaxOutstandingBytes != 0 {
+		settings.MaxOutstandingBytes = sub.MaxOutstandingBytes
+	}
+	if sub.NumGoroutines > 0 {
+		settings.NumGoroutines = sub.NumGoroutines
+	}
+	if sub.MaxExtension != 0 {
+		settings.MaxExtension = time.Duration(sub.MaxExtension) * time.Second
 	}
 
+	return settings
 }
 
-func startFileWorker(file FlingInFile, outputs map[string]interface{}) {
+// processInPubSubMessage - acks msg once every output has accepted it, a message an
+// output dropped (or that arrived as we were stopping) is nacked for redelivery
+func processInPubSubMessage(ctx context.Context, msg *pubsub.Message, sub FlingInPubSub, outputs map[string]interface{}) {
+	var logEntry map[string]interface{}
+
+	countMetric(linesRead, inputKey, sub.Subscription)
+
+	unmarshalErr := json.Unmarshal(msg.Data, &logEntry)
+	if unmarshalErr != nil {
+		log.WithFields(log.Fields{
+			"subscription": sub.Subscription,
+			"id":           msg.ID,
+			"message":      string(msg.Data),
+			"error":        unmarshalErr,
+		}).Error("Couldn't parse JSON pub/sub message")
+		countMetric(parseFailures, inputKey, sub.Subscription)
+
+		//redelivering it won't make it parse
+		msg.Ack()
+		return
+	}
+
+	logEntry["fling.source"] = fmt.Sprintf("projects/%s/subscriptions/%s", sub.Project, sub.Subscription)
+
+	if _, ok := logEntry["@timestamp"]; !ok {
+		logEntry["@timestamp"] = msg.PublishTime.UTC().Format(time.RFC3339Nano)
+	}
+
+	handleInjections(&logEntry, sub.Injections)
+
+	dispatched := ctx.Err() == nil && dispatchEntry(FlingEvent{UniqueID: msg.ID, JSON: logEntry}, sub.Outputs, outputs)
+	settlePubSubMessage(msg, dispatched)
+}
+
+// pubSubAcker - the part of a pub/sub message that settles it
+type pubSubAcker interface {
+	Ack()
+	Nack()
+}
+
+// settlePubSubMessage - acks msg if every output accepted it, otherwise nacks it so
+// pub/sub redelivers it rather than it being lost
+func settlePubSubMessage(msg pubSubAcker, dispatched bool) {
+	if dispatched {
+		msg.Ack()
+	} else {
+		msg.Nack()
+	}
+}
+
+// lineProcessor - turns the lines tailed from one file into events, returning how many
+// of the latest lines, back to the earliest it hasn't dispatched, it is holding waiting
+// for more, a nil line means it should dispatch whatever it is holding
+type lineProcessor func(line *tail.Line) int
+
+func startFileWorker(group *workerGroup, file FlingInFile, process func(file FlingInFile) lineProcessor) {
 	log.WithFields(log.Fields{
 		"path": file.Path,
 	}).Info("Adding tail for file")
-	go fileInWorker(file, outputs)
+	group.start(func() { fileInWorker(group.ctx, file, process(file), nil) })
 }
 
-func fileInWorker(file FlingInFile, outputs map[string]interface{}) {
+// fileInWorker - tails file until ctx is cancelled, or until it has gone quiet once drain
+// is closed, the registry offset only advances past lines process has finished with so a
+// restart re-reads any partial event
+func fileInWorker(ctx context.Context, file FlingInFile, process lineProcessor, drain <-chan bool) {
+	// nil until draining, then fires once no line has arrived for fileDrainQuiet
+	var quiet <-chan time.Time
+	// set while process is holding lines back, fires to make it stop waiting for more
+	var flush <-chan time.Time
+	// where each line process is holding back starts
+	var held []int64
+
 	for {
+		offset := startOffset(file)
+		tailed := openTailedFile(file.Path)
+		logger := &tailLogger{path: file.Path}
 
-		t, tailErr := tail.TailFile(file.Path, tail.Config{Follow: true, ReOpen: true, Poll: !*inotifyFlag, Location: &tail.SeekInfo{0, os.SEEK_END}})
+		t, tailErr := tail.TailFile(file.Path, tail.Config{Follow: true, ReOpen: true, Poll: !*inotifyFlag, Location: &tail.SeekInfo{Offset: offset, Whence: os.SEEK_SET}, Logger: logger})
 
 		if tailErr != nil {
 			log.WithFields(log.Fields{
@@ -494,47 +864,207 @@ func fileInWorker(file FlingInFile, outputs map[string]interface{}) {
 				"error": tailErr,
 			}).Error("Couldn't tail file")
 		} else {
-			log.Info("tailed log")
+			log.WithFields(log.Fields{
+				"path":   file.Path,
+				"offset": offset,
+			}).Info("tailed log")
+		}
+
+		flushPending := func() {
+			if flush != nil {
+				flush = nil
+				held = nil
+				process(nil)
+				registry.commit(file.Path, tailed.identity, offset)
+			}
 		}
 
 	Processing:
 		for {
 			select {
-			case line := <-t.Lines:
-				processInFileLine(line, file, outputs)
+			case line, ok := <-t.Lines:
+				if !ok {
+					log.WithFields(log.Fields{
+						"path":  file.Path,
+						"error": t.Err(),
+					}).Error("Tail stopped unexpectedly, restarting")
+					// the restarted tail resumes after the lines being held back
+					flushPending()
+					time.Sleep(time.Second)
+					break Processing
+				}
+
+				// the file was rotated or truncated underneath us, this line
+				// is the first one from the start of the new file
+				if tailed.replaced(offset) {
+					// an event held back from the old file can't continue in the new one
+					flushPending()
+					tailed.reopen()
+					offset = 0
+				}
+
+				if quiet != nil {
+					quiet = time.After(fileDrainQuiet)
+				}
+
+				countMetric(linesRead, inputKey, file.Path)
+				held = append(held, offset)
+				holding := process(line)
+
+				offset += int64(len(line.Text)) + 1
+				if holding == 0 {
+					registry.commit(file.Path, tailed.identity, offset)
+					held = held[:0]
+					flush = nil
+				} else {
+					// everything before the earliest line still held has been dispatched
+					if holding < len(held) {
+						held = append(held[:0], held[len(held)-holding:]...)
+						registry.commit(file.Path, tailed.identity, held[0])
+					}
+					flush = time.After(file.flushTimeout())
+				}
+			case <-flush:
+				flushPending()
+			case <-ctx.Done():
+				flushPending()
+				stopTail(t)
+				tailed.close()
+				return
+			case <-drain:
+				drain = nil
+				quiet = time.After(fileDrainQuiet)
+			case <-quiet:
+				log.WithFields(log.Fields{
+					"path":   file.Path,
+					"offset": offset,
+				}).Info("Removing drained tail")
+				flushPending()
+				stopTail(t)
+				tailed.close()
+				return
 			case <-time.After(time.Hour):
+				// the restarted tail resumes after the lines being held back
+				flushPending()
 				t.Stop()
 				break Processing
 			}
 		}
+		tailed.close()
+	}
+}
+
+// stopTail - stops t even if it is blocked handing us a line, anything read
+// but not yet processed is dropped and will be re-read from the registry offset
+func stopTail(t *tail.Tail) {
+	t.Kill(nil)
+	go func() {
+		for range t.Lines {
+		}
+	}()
+	t.Wait()
+}
+
+// startOffset - where to begin reading file, the last committed offset if the
+// registry still recognises the file, otherwise the configured start position
+func startOffset(file FlingInFile) int64 {
+	if offset, ok := registry.resumeOffset(file.Path); ok {
+		return offset
+	}
+
+	identity, err := statIdentity(file.Path)
+	if err != nil {
+		// file doesn't exist yet, read it from the start once it appears
+		return 0
 	}
+
+	if file.StartPosition == "beginning" {
+		return 0
+	}
+
+	return identity.Offset
 }
 
-func processInFileLine(line *tail.Line, file FlingInFile, outputs map[string]interface{}) {
+// tailLogger - routes hpcloud/tail's logging through logrus
+type tailLogger struct {
+	path string
+}
+
+func (logger *tailLogger) Print(v ...interface{}) {
+	logger.log(fmt.Sprint(v...))
+}
+
+func (logger *tailLogger) Printf(format string, v ...interface{}) {
+	logger.log(fmt.Sprintf(format, v...))
+}
+
+func (logger *tailLogger) Println(v ...interface{}) {
+	logger.log(fmt.Sprint(v...))
+}
+
+func (logger *tailLogger) Fatal(v ...interface{}) {
+	log.WithFields(log.Fields{"path": logger.path}).Fatal(v...)
+}
+
+func (logger *tailLogger) Fatalf(format string, v ...interface{}) {
+	log.WithFields(log.Fields{"path": logger.path}).Fatalf(format, v...)
+}
+
+func (logger *tailLogger) Fatalln(v ...interface{}) {
+	log.WithFields(log.Fields{"path": logger.path}).Fatal(v...)
+}
+
+func (logger *tailLogger) Panic(v ...interface{}) {
+	log.WithFields(log.Fields{"path": logger.path}).Panic(v...)
+}
+
+func (logger *tailLogger) Panicf(format string, v ...interface{}) {
+	log.WithFields(log.Fields{"path": logger.path}).Panicf(format, v...)
+}
+
+func (logger *tailLogger) Panicln(v ...interface{}) {
+	log.WithFields(log.Fields{"path": logger.path}).Panic(v...)
+}
+
+func (logger *tailLogger) log(message string) {
+	log.WithFields(log.Fields{"path": logger.path}).Debug(strings.TrimSpace(message))
+}
+
+// processInFileLine - dispatches one line read from file, or from anything read like
+// a file, fields are added to the event before its injections are applied
+func processInFileLine(text string, fields map[string]interface{}, file FlingInFile, outputs map[string]interface{}) {
 	var logEntry map[string]interface{}
 
 	log.WithFields(log.Fields{
 		"path": file.Path,
-		"line": line.Text,
+		"line": text,
 	}).Debug("Processing log line")
 
 	if file.IsJSON {
-		unmarshalErr := json.Unmarshal([]byte(line.Text), &logEntry)
+		unmarshalErr := json.Unmarshal([]byte(text), &logEntry)
 		if unmarshalErr != nil {
 			log.WithFields(log.Fields{
-				"message": line.Text,
+				"message": text,
 				"error":   unmarshalErr,
 			}).Error("Couldn't parse JSON log line")
+			countMetric(parseFailures, inputKey, file.Path)
 
+			return
+		}
+	} else if file.Parser != nil {
+		if logEntry = file.Parser.parseLine(text, file.Path); logEntry == nil {
 			return
 		}
 	} else {
 		logEntry = make(map[string]interface{})
-		logEntry["message"] = line.Text
+		logEntry["message"] = text
 	}
 
 	//FIXME: Inject other pertinent context info
 	logEntry["fling.source"] = file.Path
+	for field, value := range fields {
+		logEntry[field] = value
+	}
 
 	if _, ok := logEntry["@timestamp"]; !ok {
 		logEntry["@timestamp"] = get3339Time()
@@ -558,19 +1088,50 @@ func handleInjections(logEntry *map[string]interface{}, injections []FlingInject
 	}
 }
 
-func dispatchEntry(event FlingEvent, outputs []string, channels map[string]interface{}) {
+// dispatchEntry - sends event to each of outputs, false if any of them dropped it
+func dispatchEntry(event FlingEvent, outputs []string, channels map[string]interface{}) bool {
+	accepted := true
 	for _, output := range outputs {
-		channels[output].(chan FlingEvent) <- event
+		queue, ok := channels[output].(*outputQueue)
+		if !ok {
+			// validation rejects routes to outputs that don't run, this is a bug if it happens
+			log.WithFields(log.Fields{
+				"OutputName": output,
+				"UniqueID":   event.UniqueID,
+			}).Error("Output isn't running, dropping event")
+			countMetric(eventsDropped, outputKey, output)
+			accepted = false
+			continue
+		}
+
+		if queue.send(event) {
+			countMetric(eventsDispatched, outputKey, output)
+		} else {
+			accepted = false
+		}
 	}
+
+	return accepted
 }
 
-func handleRotations(rotations []FlingRotation) {
+func handleRotations(rotations []FlingRotation) []configuredWorker {
+	var configured []configuredWorker
+
 	for _, rotation := range rotations {
-		go rotateWorker(rotation)
+		rotation := rotation
+		configured = append(configured, configuredWorker{
+			name: "rotation " + strings.Join(rotation.Files, ","),
+			key:  configKey("rotation", rotation),
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { rotateWorker(group.ctx, rotation) })
+			},
+		})
 	}
+
+	return configured
 }
 
-func rotateWorker(rotation FlingRotation) {
+func rotateWorker(ctx context.Context, rotation FlingRotation) {
 	rand.Seed(time.Now().UnixNano())
 
 	for {
@@ -589,7 +1150,11 @@ func rotateWorker(rotation FlingRotation) {
 			"seconds": interval,
 		}).Info("Sleeping before rotate")
 
-		time.Sleep(time.Duration(interval) * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
 
 		rotate(rotation)
 	}
@@ -609,6 +1174,8 @@ func rotate(rotation FlingRotation) {
 
 				continue
 			} else {
+				registry.rename(file, file+".old")
+
 				log.WithFields(log.Fields{
 					"path": file,
 				}).Info("Moved log file")
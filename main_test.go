@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestDispatchEntryMissingOutput(t *testing.T) {
+	running := &outputQueue{name: "running", policy: overflowDropNewest, channel: make(chan FlingEvent, 1)}
+	channels := map[string]interface{}{"running": running}
+
+	if dispatchEntry(FlingEvent{JSON: map[string]interface{}{}}, []string{"missing", "running"}, channels) {
+		t.Error("dispatch to an output that isn't running reported success")
+	}
+	if len(running.channel) != 1 {
+		t.Error("event wasn't dispatched to the output that is running")
+	}
+}
+
+// testAcker - records how a pub/sub message was settled
+type testAcker struct {
+	acked  int
+	nacked int
+}
+
+func (msg *testAcker) Ack()  { msg.acked++ }
+func (msg *testAcker) Nack() { msg.nacked++ }
+
+func TestSettlePubSubMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		full     bool // the second output's buffer is full and it drops newest
+		stopping bool
+		wantAck  bool
+	}{
+		{name: "every output accepted", wantAck: true},
+		{name: "an output dropped it", full: true},
+		{name: "input stopping", stopping: true},
+	}
+
+	for _, test := range tests {
+		first := &outputQueue{name: "first", policy: overflowDropNewest, channel: make(chan FlingEvent, 1)}
+		second := &outputQueue{name: "second", policy: overflowDropNewest, channel: make(chan FlingEvent, 1)}
+		if test.full {
+			second.channel <- FlingEvent{}
+		}
+		channels := map[string]interface{}{"first": first, "second": second}
+
+		msg := &testAcker{}
+		dispatched := !test.stopping && dispatchEntry(FlingEvent{JSON: map[string]interface{}{}}, []string{"first", "second"}, channels)
+		settlePubSubMessage(msg, dispatched)
+
+		switch {
+		case msg.acked+msg.nacked != 1:
+			t.Errorf("%s: acked %d and nacked %d times, want settled once", test.name, msg.acked, msg.nacked)
+		case test.wantAck && msg.acked != 1:
+			t.Errorf("%s: nacked, want acked", test.name)
+		case !test.wantAck && msg.nacked != 1:
+			t.Errorf("%s: acked, want nacked for redelivery", test.name)
+		}
+	}
+}
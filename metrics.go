@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+//FlingMonitoring - optional HTTP listener serving prometheus metrics on /metrics
+// and health checks on /healthz and /readyz
+type FlingMonitoring struct {
+	Listen string `json:"listen"`
+	// seconds an output can fail continuously before /healthz reports it
+	UnhealthyAfter int `json:"unhealthy_after,omitempty"`
+}
+
+var (
+	inputKey, _  = tag.NewKey("input")
+	outputKey, _ = tag.NewKey("output")
+
+	linesRead          = stats.Int64("fling/lines_read", "Lines or messages read from inputs", stats.UnitDimensionless)
+	parseFailures      = stats.Int64("fling/parse_failures", "Lines that couldn't be parsed", stats.UnitDimensionless)
+	eventsDispatched   = stats.Int64("fling/events_dispatched", "Events dispatched to an output", stats.UnitDimensionless)
+	eventsDropped      = stats.Int64("fling/events_dropped", "Events dropped by an output's overflow policy", stats.UnitDimensionless)
+	eventsPublished    = stats.Int64("fling/events_published", "Events delivered by an output", stats.UnitDimensionless)
+	publishErrors      = stats.Int64("fling/publish_errors", "Failed delivery attempts, retries included", stats.UnitDimensionless)
+	eventsDeadLettered = stats.Int64("fling/events_dead_lettered", "Events an output gave up delivering", stats.UnitDimensionless)
+	publishLatency     = stats.Float64("fling/publish_latency", "Time from publish to acknowledgement", stats.UnitMilliseconds)
+	queueDepth         = stats.Int64("fling/queue_depth", "Events buffered for an output", stats.UnitDimensionless)
+
+	// rendered in this order, counts become prometheus counters, last values gauges
+	// and distributions histograms
+	metricViews = []*view.View{
+		{Measure: linesRead, TagKeys: []tag.Key{inputKey}, Aggregation: view.Count()},
+		{Measure: parseFailures, TagKeys: []tag.Key{inputKey}, Aggregation: view.Count()},
+		{Measure: eventsDispatched, TagKeys: []tag.Key{outputKey}, Aggregation: view.Count()},
+		{Measure: eventsDropped, TagKeys: []tag.Key{outputKey}, Aggregation: view.Count()},
+		{Measure: eventsPublished, TagKeys: []tag.Key{outputKey}, Aggregation: view.Count()},
+		{Measure: publishErrors, TagKeys: []tag.Key{outputKey}, Aggregation: view.Count()},
+		{Measure: eventsDeadLettered, TagKeys: []tag.Key{outputKey}, Aggregation: view.Count()},
+		{Measure: publishLatency, TagKeys: []tag.Key{outputKey}, Aggregation: view.Distribution(5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000)},
+		{Measure: queueDepth, TagKeys: []tag.Key{outputKey}, Aggregation: view.LastValue()},
+	}
+)
+
+// countMetric - counts one occurrence of measure against an input or output
+func countMetric(measure *stats.Int64Measure, key tag.Key, value string) {
+	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(key, value)}, measure.M(1))
+}
+
+func recordPublishLatency(output string, started time.Time) {
+	elapsed := float64(time.Since(started)) / float64(time.Millisecond)
+	stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(outputKey, output)}, publishLatency.M(elapsed))
+}
+
+// serveMonitoring - registers the views, nothing is aggregated until then, and
+// serves them and the health checks until the process exits
+func serveMonitoring(config FlingMonitoring, pipe *pipeline) {
+	if err := view.Register(metricViews...); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Couldn't register metric views")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthHandler(func() []string { return pipe.notLive(config.unhealthyAfter()) }))
+	mux.HandleFunc("/readyz", healthHandler(pipe.notReady))
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		recordQueueDepths(pipe)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+
+	log.WithFields(log.Fields{
+		"listen": config.Listen,
+	}).Info("Serving monitoring endpoints")
+
+	if err := http.ListenAndServe(config.Listen, mux); err != nil {
+		log.WithFields(log.Fields{
+			"listen": config.Listen,
+			"error":  err,
+		}).Error("Monitoring listener stopped")
+	}
+}
+
+// recordQueueDepths - samples how full every output is at scrape time
+func recordQueueDepths(pipe *pipeline) {
+	for name, channel := range pipe.currentChannels() {
+		depth := int64(len(channel.(*outputQueue).channel))
+		stats.RecordWithTags(context.Background(), []tag.Mutator{tag.Upsert(outputKey, name)}, queueDepth.M(depth))
+	}
+}
+
+// writeMetrics - renders every view in the prometheus text exposition format, done by
+// hand because opencensus' prometheus exporter needs prometheus/client_golang, which isn't vendored
+func writeMetrics(w io.Writer) {
+	for _, v := range metricViews {
+		rows, err := view.RetrieveData(v.Name)
+		if err != nil {
+			continue
+		}
+		sort.Slice(rows, func(i, j int) bool { return labels(rows[i].Tags, "") < labels(rows[j].Tags, "") })
+
+		name := "fling_" + strings.Replace(strings.TrimPrefix(v.Name, "fling/"), "/", "_", -1)
+
+		switch v.Aggregation.Type {
+		case view.AggTypeCount:
+			fmt.Fprintf(w, "# HELP %s_total %s\n# TYPE %s_total counter\n", name, v.Description, name)
+			for _, row := range rows {
+				fmt.Fprintf(w, "%s_total%s %d\n", name, labels(row.Tags, ""), row.Data.(*view.CountData).Value)
+			}
+		case view.AggTypeLastValue:
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, v.Description, name)
+			for _, row := range rows {
+				fmt.Fprintf(w, "%s%s %s\n", name, labels(row.Tags, ""), formatFloat(row.Data.(*view.LastValueData).Value))
+			}
+		case view.AggTypeDistribution:
+			name += "_" + v.Measure.Unit()
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, v.Description, name)
+			for _, row := range rows {
+				data := row.Data.(*view.DistributionData)
+
+				// opencensus buckets aren't cumulative, prometheus ones are
+				var cumulative int64
+				for i, bound := range v.Aggregation.Buckets {
+					cumulative += data.CountPerBucket[i]
+					fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(row.Tags, formatFloat(bound)), cumulative)
+				}
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(row.Tags, "+Inf"), data.Count)
+				fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(row.Tags, ""), formatFloat(data.Sum()))
+				fmt.Fprintf(w, "%s_count%s %d\n", name, labels(row.Tags, ""), data.Count)
+			}
+		}
+	}
+}
+
+// the only escapes the exposition format has in label values, anything else is written as is
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels - {key="value",...} for a row's tags, plus le when rendering a histogram bucket
+func labels(tags []tag.Tag, le string) string {
+	var pairs []string
+	for _, t := range tags {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, t.Key.Name(), labelEscaper.Replace(t.Value)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"go.opencensus.io/tag"
+)
+
+func TestLabelsEscaping(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"/var/log/app.log", `{input="/var/log/app.log"}`},
+		{"/var/log/café/日誌.log", `{input="/var/log/café/日誌.log"}`},
+		{`C:\logs\"quoted"`, `{input="C:\\logs\\\"quoted\""}`},
+		{"two\nlines\ttab", `{input="two\nlines` + "\t" + `tab"}`},
+	}
+
+	for _, test := range tests {
+		if got := labels([]tag.Tag{{Key: inputKey, Value: test.value}}, ""); got != test.want {
+			t.Errorf("labels(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	if got := labels([]tag.Tag{{Key: outputKey, Value: "out"}}, "+Inf"); got != `{output="out",le="+Inf"}` {
+		t.Errorf("labels with le = %s", got)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// msgpackExt - a MessagePack extension value, fluentd uses type 0 for EventTime
+type msgpackExt struct {
+	Type int8
+	Data []byte
+}
+
+// msgpackDecoder - decodes MessagePack values into the types encoding/json would
+// produce, maps into map[string]interface{}, plus []byte for bin and msgpackExt
+type msgpackDecoder struct {
+	reader *bufio.Reader
+	// largest str, bin, ext, array or map accepted, so a bad length can't exhaust memory
+	maxLength int
+	depth     int
+}
+
+// nesting beyond this is rejected rather than recursed into
+const msgpackMaxDepth = 100
+
+func newMsgpackDecoder(reader io.Reader, maxLength int) *msgpackDecoder {
+	return &msgpackDecoder{reader: bufio.NewReader(reader), maxLength: maxLength}
+}
+
+// decode - the next value, io.EOF if the stream ended cleanly between values
+func (decoder *msgpackDecoder) decode() (interface{}, error) {
+	code, err := decoder.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := decoder.decodeValue(code)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return value, err
+}
+
+func (decoder *msgpackDecoder) decodeValue(code byte) (interface{}, error) {
+	switch {
+	case code <= 0x7f:
+		return int64(code), nil
+	case code >= 0xe0:
+		return int64(int8(code)), nil
+	case code >= 0x80 && code <= 0x8f:
+		return decoder.decodeMap(int(code & 0x0f))
+	case code >= 0x90 && code <= 0x9f:
+		return decoder.decodeArray(int(code & 0x0f))
+	case code >= 0xa0 && code <= 0xbf:
+		data, err := decoder.readBytes(int(code & 0x1f))
+		return string(data), err
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, err := decoder.readLength(code - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.readBytes(length)
+	case 0xc7, 0xc8, 0xc9:
+		length, err := decoder.readLength(code - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.readExt(length)
+	case 0xca:
+		data, err := decoder.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case 0xcb:
+		data, err := decoder.readBytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		data, err := decoder.readBytes(1 << (code - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return bigEndianUint(data), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		data, err := decoder.readBytes(1 << (code - 0xd0))
+		if err != nil {
+			return nil, err
+		}
+		// sign extend from the top bit of the value's width
+		shift := 64 - uint(len(data))*8
+		return int64(bigEndianUint(data)<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decoder.readExt(1 << (code - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		length, err := decoder.readLength(code - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		data, err := decoder.readBytes(length)
+		return string(data), err
+	case 0xdc, 0xdd:
+		length, err := decoder.readLength(code - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.decodeArray(length)
+	case 0xde, 0xdf:
+		length, err := decoder.readLength(code - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.decodeMap(length)
+	}
+
+	return nil, fmt.Errorf("msgpack: invalid type code 0x%02x", code)
+}
+
+func bigEndianUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+
+	return value
+}
+
+// readLength - a 1, 2 or 4 byte length for size 0, 1 or 2
+func (decoder *msgpackDecoder) readLength(size byte) (int, error) {
+	data, err := decoder.readBytes(1 << size)
+	if err != nil {
+		return 0, err
+	}
+
+	length := bigEndianUint(data)
+	if length > uint64(decoder.maxLength) {
+		return 0, fmt.Errorf("msgpack: length %d exceeds the %d limit", length, decoder.maxLength)
+	}
+
+	return int(length), nil
+}
+
+func (decoder *msgpackDecoder) readBytes(length int) ([]byte, error) {
+	data := make([]byte, length)
+	_, err := io.ReadFull(decoder.reader, data)
+
+	return data, err
+}
+
+func (decoder *msgpackDecoder) readExt(length int) (interface{}, error) {
+	extType, err := decoder.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := decoder.readBytes(length)
+
+	return msgpackExt{Type: int8(extType), Data: data}, err
+}
+
+func (decoder *msgpackDecoder) decodeArray(length int) (interface{}, error) {
+	if decoder.depth++; decoder.depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("msgpack: nested more than %d deep", msgpackMaxDepth)
+	}
+	defer func() { decoder.depth-- }()
+
+	// grown as elements arrive rather than trusting the declared length
+	values := make([]interface{}, 0, minInt(length, 1024))
+	for i := 0; i < length; i++ {
+		value, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (decoder *msgpackDecoder) decodeMap(length int) (interface{}, error) {
+	if decoder.depth++; decoder.depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("msgpack: nested more than %d deep", msgpackMaxDepth)
+	}
+	defer func() { decoder.depth-- }()
+
+	values := make(map[string]interface{}, minInt(length, 1024))
+	for i := 0; i < length; i++ {
+		key, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decoder.decode()
+		if err != nil {
+			return nil, err
+		}
+
+		switch k := key.(type) {
+		case string:
+			values[k] = value
+		case []byte:
+			values[string(k)] = value
+		default:
+			values[fmt.Sprint(k)] = value
+		}
+	}
+
+	return values, nil
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// encodeMsgpackStringMap - the encoding of a map of strings, all fluent forward acks need
+func encodeMsgpackStringMap(values map[string]string) []byte {
+	var encoded []byte
+
+	encoded = append(encoded, 0xde, byte(len(values)>>8), byte(len(values)))
+	for key, value := range values {
+		encoded = appendMsgpackString(encoded, key)
+		encoded = appendMsgpackString(encoded, value)
+	}
+
+	return encoded
+}
+
+func appendMsgpackString(encoded []byte, value string) []byte {
+	encoded = append(encoded, 0xdb)
+	encoded = append(encoded, byte(len(value)>>24), byte(len(value)>>16), byte(len(value)>>8), byte(len(value)))
+
+	return append(encoded, value...)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackDecoder(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+		err  bool
+	}{
+		{name: "positive fixint", data: "\x05", want: int64(5)},
+		{name: "negative fixint", data: "\xff", want: int64(-1)},
+		{name: "fixstr", data: "\xa3abc", want: "abc"},
+		{name: "str8", data: "\xd9\x02hi", want: "hi"},
+		{name: "bin8", data: "\xc4\x02hi", want: []byte("hi")},
+		{name: "nil and bools", data: "\x93\xc0\xc3\xc2", want: []interface{}{nil, true, false}},
+		{name: "uint16", data: "\xcd\x01\x00", want: uint64(256)},
+		{name: "int8", data: "\xd0\xff", want: int64(-1)},
+		{name: "int16", data: "\xd1\xff\x00", want: int64(-256)},
+		{name: "int64", data: "\xd3\x80\x00\x00\x00\x00\x00\x00\x00", want: int64(-1 << 63)},
+		{name: "float32", data: "\xca\x3f\x80\x00\x00", want: 1.0},
+		{name: "float64", data: "\xcb\x3f\xf0\x00\x00\x00\x00\x00\x00", want: 1.0},
+		{name: "fixext4", data: "\xd6\x00\x01\x02\x03\x04", want: msgpackExt{Type: 0, Data: []byte{1, 2, 3, 4}}},
+		{name: "ext8", data: "\xc7\x02\x05ab", want: msgpackExt{Type: 5, Data: []byte("ab")}},
+		{name: "fixmap", data: "\x82\xa1a\x01\xa1b\x91\xa1c", want: map[string]interface{}{"a": int64(1), "b": []interface{}{"c"}}},
+		{name: "map with a non string key", data: "\x81\x01\x02", want: map[string]interface{}{"1": int64(2)}},
+		{name: "map16", data: "\xde\x00\x01\xc4\x01k\xc0", want: map[string]interface{}{"k": nil}},
+		{name: "empty", data: "", err: true},
+		{name: "never used code", data: "\xc1", err: true},
+		{name: "truncated str", data: "\xa3a", err: true},
+		{name: "truncated map", data: "\x82\xa1a\x01", err: true},
+		{name: "truncated length", data: "\xda\x01", err: true},
+		{name: "str longer than the limit", data: "\xdb\xff\xff\xff\xff", err: true},
+		{name: "array longer than the limit", data: "\xdd\xff\xff\xff\xff", err: true},
+		{name: "array shorter than it claims", data: "\xdc\x03\xff\x01", err: true},
+		{name: "nested too deep", data: strings.Repeat("\x91", msgpackMaxDepth+1) + "\xc0", err: true},
+	}
+
+	for _, test := range tests {
+		got, err := newMsgpackDecoder(strings.NewReader(test.data), 1024).decode()
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: decoded %v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMsgpackDecoderStream(t *testing.T) {
+	decoder := newMsgpackDecoder(strings.NewReader("\x01\xa1a"), 1024)
+
+	var values []interface{}
+	for {
+		value, err := decoder.decode()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	if want := []interface{}{int64(1), "a"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("values %v, want %v", values, want)
+	}
+
+	// a value cut off part way isn't a clean end of the stream
+	if _, err := newMsgpackDecoder(strings.NewReader("\xa2a"), 1024).decode(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated value returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestEncodeMsgpackStringMap(t *testing.T) {
+	encoded := encodeMsgpackStringMap(map[string]string{"ack": "chunk-id"})
+
+	decoded, err := newMsgpackDecoder(bytes.NewReader(encoded), 1024).decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"ack": "chunk-id"}; !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded %v, want %v", decoded, want)
+	}
+}
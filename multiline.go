@@ -0,0 +1,130 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hpcloud/tail"
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingMultiline - joins the lines of one event, such as an exception and its stack trace,
+// into a single message, set either a start_pattern or a continuation_pattern
+type FlingMultiline struct {
+	StartPattern        string `json:"start_pattern,omitempty"`        // lines matching it start a new event
+	ContinuationPattern string `json:"continuation_pattern,omitempty"` // lines matching it belong to the event before
+	Negate              bool   `json:"negate,omitempty"`               // lines NOT matching the pattern start (or continue) an event instead
+	MaxLines            int    `json:"max_lines,omitempty"`            // further lines of an event are discarded, default 500
+	MaxBytes            int    `json:"max_bytes,omitempty"`            // messages are truncated to this length, default 1MB
+	FlushTimeout        int    `json:"flush_timeout,omitempty"`        // seconds an event waits for more lines, default 5
+}
+
+func (multiline *FlingMultiline) maxLines() int {
+	if multiline.MaxLines == 0 {
+		return 500
+	}
+
+	return multiline.MaxLines
+}
+
+func (multiline *FlingMultiline) maxBytes() int {
+	if multiline.MaxBytes == 0 {
+		return 1024 * 1024
+	}
+
+	return multiline.MaxBytes
+}
+
+// flushTimeout - how long a line processor may hold lines back before it is asked to
+// dispatch them anyway
+func (file FlingInFile) flushTimeout() time.Duration {
+	if file.Multiline == nil || file.Multiline.FlushTimeout == 0 {
+		return 5 * time.Second
+	}
+
+	return time.Duration(file.Multiline.FlushTimeout) * time.Second
+}
+
+// multilineEvent - the lines of the event being assembled
+type multilineEvent struct {
+	lines     []string
+	bytes     int
+	truncated bool
+}
+
+// add - appends line unless the event already has max_lines lines or max_bytes bytes
+func (event *multilineEvent) add(line string, multiline *FlingMultiline) {
+	if len(event.lines) >= multiline.maxLines() {
+		event.truncated = true
+		return
+	}
+
+	// counting the newline joining it to the line before
+	if len(event.lines) > 0 {
+		event.bytes++
+	}
+	if room := multiline.maxBytes() - event.bytes; len(line) > room {
+		event.truncated = true
+		if room <= 0 {
+			return
+		}
+		line = line[:room]
+	}
+
+	event.lines = append(event.lines, line)
+	event.bytes += len(line)
+}
+
+// multilineProcessor - hands process one message per event rather than one per line, the
+// event being assembled is held back until a line that isn't part of it arrives, or
+// until nothing has arrived for flush_timeout
+func multilineProcessor(file FlingInFile, process func(text string)) lineProcessor {
+	multiline := file.Multiline
+	pattern := multiline.StartPattern
+	if pattern == "" {
+		pattern = multiline.ContinuationPattern
+	}
+	// validated when the config was loaded
+	matcher := regexp.MustCompile(pattern)
+
+	var pending *multilineEvent
+	// lines in pending, counting those discarded past max_lines
+	held := 0
+	dispatch := func() {
+		held = 0
+		if pending == nil {
+			return
+		}
+		if pending.truncated {
+			log.WithFields(log.Fields{
+				"path":  file.Path,
+				"lines": len(pending.lines),
+			}).Warn("Multiline event exceeded max_lines or max_bytes, truncating it")
+		}
+		process(strings.Join(pending.lines, "\n"))
+		pending = nil
+	}
+
+	return func(line *tail.Line) int {
+		if line == nil {
+			dispatch()
+			return 0
+		}
+
+		matched := matcher.MatchString(line.Text) != multiline.Negate
+		startsEvent := matched
+		if multiline.StartPattern == "" {
+			startsEvent = !matched
+		}
+
+		if startsEvent || pending == nil {
+			dispatch()
+			pending = &multilineEvent{}
+		}
+		pending.add(line.Text, multiline)
+		held++
+
+		return held
+	}
+}
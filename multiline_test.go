@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hpcloud/tail"
+)
+
+func TestMultilineProcessor(t *testing.T) {
+	tests := []struct {
+		name      string
+		multiline FlingMultiline
+		lines     []string
+		held      []int
+		events    []string
+	}{
+		{
+			name:      "start pattern",
+			multiline: FlingMultiline{StartPattern: `^\d`},
+			lines:     []string{"1 error", "  at a", "  at b", "2 ok", "3 error", "  at c"},
+			held:      []int{1, 2, 3, 1, 1, 2},
+			events:    []string{"1 error\n  at a\n  at b", "2 ok", "3 error\n  at c"},
+		},
+		{
+			name:      "continuation pattern",
+			multiline: FlingMultiline{ContinuationPattern: `^\s`},
+			lines:     []string{"one", " more", "two"},
+			held:      []int{1, 2, 1},
+			events:    []string{"one\n more", "two"},
+		},
+		{
+			name:      "negated start pattern",
+			multiline: FlingMultiline{StartPattern: `^\s`, Negate: true},
+			lines:     []string{"one", " more", "two"},
+			held:      []int{1, 2, 1},
+			events:    []string{"one\n more", "two"},
+		},
+		{
+			name:      "continuation before any start",
+			multiline: FlingMultiline{StartPattern: `^\d`},
+			lines:     []string{"  orphan", "1 start"},
+			held:      []int{1, 1},
+			events:    []string{"  orphan", "1 start"},
+		},
+		{
+			name:      "max lines",
+			multiline: FlingMultiline{StartPattern: `^\d`, MaxLines: 2},
+			lines:     []string{"1", "a", "b", "c"},
+			held:      []int{1, 2, 3, 4},
+			events:    []string{"1\na"},
+		},
+		{
+			name:      "max bytes",
+			multiline: FlingMultiline{StartPattern: `^\d`, MaxBytes: 8},
+			lines:     []string{"1 abc", "defgh", "ijk"},
+			held:      []int{1, 2, 3},
+			events:    []string{"1 abc\nde"},
+		},
+	}
+
+	for _, test := range tests {
+		var events []string
+		multiline := test.multiline
+		process := multilineProcessor(FlingInFile{Path: "test.log", Multiline: &multiline}, func(text string) {
+			events = append(events, text)
+		})
+
+		var held []int
+		for _, line := range test.lines {
+			held = append(held, process(&tail.Line{Text: line}))
+		}
+		if flushed := process(nil); flushed != 0 {
+			t.Errorf("%s: flushing left %d lines held", test.name, flushed)
+		}
+
+		if !reflect.DeepEqual(held, test.held) {
+			t.Errorf("%s: held %v, want %v", test.name, held, test.held)
+		}
+		if !reflect.DeepEqual(events, test.events) {
+			t.Errorf("%s: events %q, want %q", test.name, events, test.events)
+		}
+	}
+}
+
+func TestFileInWorkerCommitsDispatchedEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-multiline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(previous *offsetRegistry) { registry = previous }(registry)
+	registry = newOffsetRegistry("")
+
+	logPath := filepath.Join(dir, "app.log")
+	first := "1 error\n  at a\n"
+	writeTestFile(t, logPath, first+"2 error\n  at b\n")
+
+	file := FlingInFile{Path: logPath, StartPosition: "beginning", Multiline: &FlingMultiline{StartPattern: `^\d`, FlushTimeout: 3600}}
+	events := make(chan string, 10)
+	process := multilineProcessor(file, func(text string) { events <- text })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		fileInWorker(ctx, file, process, nil)
+		close(done)
+	}()
+
+	select {
+	case event := <-events:
+		if !strings.HasPrefix(event, "1 error") {
+			t.Fatalf("first event %q", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("first event wasn't dispatched")
+	}
+
+	// the second event is held until the next one starts, but the first is committed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		offset, _ := registry.resumeOffset(logPath)
+		if offset == int64(len(first)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("committed offset %d, want %d", offset, len(first))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// stopping dispatches the held event rather than dropping it
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("worker didn't stop")
+	}
+	select {
+	case event := <-events:
+		if event != "2 error\n  at b" {
+			t.Errorf("held event %q", event)
+		}
+	default:
+		t.Fatal("held event wasn't dispatched when the worker stopped")
+	}
+	if offset, _ := registry.resumeOffset(logPath); offset != int64(len(first))+15 {
+		t.Errorf("committed offset %d after stopping, want the end of the file", offset)
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // OTLP exporters commonly gzip their requests
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+//FlingInOTLP - an OpenTelemetry OTLP/gRPC logs receiver, so services instrumented with an
+// OpenTelemetry SDK can export their logs straight to fling
+type FlingInOTLP struct {
+	Listen          string           `json:"listen"`
+	TLS             *FlingTLS        `json:"tls,omitempty"`
+	MaxMessageBytes int              `json:"max_message_bytes,omitempty"` // largest export request accepted, default 4MB
+	Outputs         []string         `json:"outputs"`
+	Injections      []FlingInjection `json:"injections"`
+}
+
+// the wire type of each AnyValue field, string, bool, int, double, array, kvlist and bytes
+var otlpAnyValueWireTypes = map[int]int{
+	1: protobufBytes,
+	2: protobufVarint,
+	3: protobufVarint,
+	4: protobufFixed64,
+	5: protobufBytes,
+	6: protobufBytes,
+	7: protobufBytes,
+}
+
+// nesting of array and kvlist values beyond this is rejected rather than recursed into
+const otlpMaxDepth = 100
+
+func (input FlingInOTLP) maxMessageBytes() int {
+	if input.MaxMessageBytes == 0 {
+		return 4 * 1024 * 1024
+	}
+
+	return input.MaxMessageBytes
+}
+
+// source - how the input is labelled in metrics and logs
+func (input FlingInOTLP) source() string {
+	return "otlp " + input.Listen
+}
+
+func handleInOTLPs(inputs []FlingInOTLP) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input " + input.source(),
+			key:     configKey("otlp", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { otlpInWorker(group.ctx, input, outputs) })
+			},
+		})
+	}
+
+	return configured
+}
+
+// otlpCodec - hands the server the raw request bytes and sends raw response bytes, the
+// OTLP messages are decoded by hand rather than with generated code
+type otlpCodec struct{}
+
+func (otlpCodec) Marshal(v interface{}) ([]byte, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("otlp codec can't marshal %T", v)
+	}
+
+	return data, nil
+}
+
+func (otlpCodec) Unmarshal(data []byte, v interface{}) error {
+	request, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("otlp codec can't unmarshal into %T", v)
+	}
+	*request = append([]byte(nil), data...)
+
+	return nil
+}
+
+func (otlpCodec) String() string {
+	return "otlp"
+}
+
+// otlpLogsService - what grpc checks the receiver implements when it is registered
+type otlpLogsService interface {
+	export(ctx context.Context, request []byte) ([]byte, error)
+}
+
+// otlpLogsServiceDesc - opentelemetry.proto.collector.logs.v1.LogsService, which has the one Export method
+var otlpLogsServiceDesc = grpc.ServiceDesc{
+	ServiceName: "opentelemetry.proto.collector.logs.v1.LogsService",
+	HandlerType: (*otlpLogsService)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Export",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			var request []byte
+			if err := dec(&request); err != nil {
+				return nil, err
+			}
+			return srv.(otlpLogsService).export(ctx, request)
+		},
+	}},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "opentelemetry/proto/collector/logs/v1/logs_service.proto",
+}
+
+type otlpReceiver struct {
+	ctx     context.Context
+	input   FlingInOTLP
+	outputs map[string]interface{}
+
+	// exports hold a read lock while they run, so taking the write lock waits for them all
+	handlers sync.RWMutex
+	closed   bool
+}
+
+// close - waits for exports still being handled, and fails any that come after, so
+// nothing is dispatched once the outputs are closed
+func (receiver *otlpReceiver) close() {
+	receiver.handlers.Lock()
+	receiver.closed = true
+	receiver.handlers.Unlock()
+}
+
+// otlpInWorker - serves the receiver until ctx is cancelled, then lets exports already
+// being handled finish dispatching before returning
+func otlpInWorker(ctx context.Context, input FlingInOTLP, outputs map[string]interface{}) {
+	options := []grpc.ServerOption{
+		grpc.CustomCodec(otlpCodec{}),
+		grpc.MaxRecvMsgSize(input.maxMessageBytes()),
+	}
+
+	if input.TLS != nil {
+		fields := log.Fields{"listen": input.Listen}
+		var serverConfig credentials.TransportCredentials
+		loaded := retryUntilDone(ctx, fields, "Couldn't load TLS certificate, retrying", func() error {
+			tlsConfig, err := input.TLS.serverConfig()
+			if err == nil {
+				// credentials sets up the h2 ALPN gRPC clients insist on
+				serverConfig = credentials.NewTLS(tlsConfig)
+			}
+			return err
+		})
+		if !loaded {
+			return
+		}
+		options = append(options, grpc.Creds(serverConfig))
+	}
+
+	listener := listenUntilDone(ctx, "tcp", input.Listen, nil)
+	if listener == nil {
+		return
+	}
+
+	receiver := &otlpReceiver{ctx: ctx, input: input, outputs: outputs}
+	server := grpc.NewServer(options...)
+	server.RegisterService(&otlpLogsServiceDesc, receiver)
+
+	stopped := make(chan bool)
+	go func() {
+		<-ctx.Done()
+		graceful := make(chan bool)
+		go func() {
+			server.GracefulStop()
+			close(graceful)
+		}()
+
+		select {
+		case <-graceful:
+		case <-time.After(10 * time.Second):
+			// Stop doesn't wait for handlers that are still running
+			server.Stop()
+		}
+		receiver.close()
+		close(stopped)
+	}()
+
+	err := server.Serve(listener)
+	if ctx.Err() != nil {
+		<-stopped
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"listen": input.Listen,
+		"error":  err,
+	}).Error("OTLP input stopped")
+}
+
+// export - dispatches every log record in an ExportLogsServiceRequest, failing the export
+// with Unavailable, which exporters retry, unless every output accepted every record
+func (receiver *otlpReceiver) export(ctx context.Context, request []byte) ([]byte, error) {
+	input := receiver.input
+
+	receiver.handlers.RLock()
+	defer receiver.handlers.RUnlock()
+	if receiver.closed || receiver.ctx.Err() != nil {
+		return nil, status.Error(codes.Unavailable, "shutting down")
+	}
+
+	// push back rather than block the exporter, or drop what it sent, while an output is backed up
+	for _, output := range input.Outputs {
+		if queue, ok := receiver.outputs[output].(*outputQueue); ok && queue.full() {
+			return nil, status.Errorf(codes.Unavailable, "output %s is full", output)
+		}
+	}
+
+	entries, err := decodeOTLPLogs(request)
+	if err != nil {
+		countMetric(parseFailures, inputKey, input.source())
+		return nil, status.Errorf(codes.InvalidArgument, "invalid logs export: %v", err)
+	}
+
+	sender := "otlp://"
+	if client, ok := peer.FromContext(ctx); ok {
+		sender += client.Addr.String()
+	}
+
+	accepted := true
+	for _, logEntry := range entries {
+		countMetric(linesRead, inputKey, input.source())
+
+		logEntry["fling.source"] = sender
+		handleInjections(&logEntry, input.Injections)
+
+		accepted = dispatchEntry(FlingEvent{UniqueID: "", JSON: logEntry}, input.Outputs, receiver.outputs) && accepted
+	}
+
+	if !accepted {
+		// an output filled up part way through and its overflow policy dropped records
+		return nil, status.Error(codes.Unavailable, "some log records were dropped")
+	}
+
+	// an empty ExportLogsServiceResponse, everything was accepted
+	return []byte{}, nil
+}
+
+// decodeOTLPLogs - an event for each log record in an ExportLogsServiceRequest
+func decodeOTLPLogs(request []byte) ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+
+	err := walkProtobuf(request, func(field protobufField) error {
+		if field.number != 1 || field.wireType != protobufBytes {
+			return nil
+		}
+		resourceEntries, err := decodeOTLPResourceLogs(field.data)
+		entries = append(entries, resourceEntries...)
+		return err
+	})
+
+	return entries, err
+}
+
+// decodeOTLPResourceLogs - ResourceLogs, the resource's attributes are added to each of its log records
+func decodeOTLPResourceLogs(data []byte) ([]map[string]interface{}, error) {
+	var resource map[string]interface{}
+	var scopeLogs [][]byte
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		if field.wireType != protobufBytes {
+			return nil
+		}
+
+		switch field.number {
+		case 1:
+			return walkProtobuf(field.data, func(field protobufField) error {
+				if field.number != 1 || field.wireType != protobufBytes {
+					return nil
+				}
+				if resource == nil {
+					resource = make(map[string]interface{})
+				}
+				return decodeOTLPKeyValue(field.data, resource, 0)
+			})
+		case 2:
+			// the resource may come after its scopes
+			scopeLogs = append(scopeLogs, field.data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]interface{}
+	for _, scope := range scopeLogs {
+		scopeEntries, err := decodeOTLPScopeLogs(scope, resource)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, scopeEntries...)
+	}
+
+	return entries, nil
+}
+
+// decodeOTLPScopeLogs - ScopeLogs, the instrumentation scope and its log records
+func decodeOTLPScopeLogs(data []byte, resource map[string]interface{}) ([]map[string]interface{}, error) {
+	var scope map[string]interface{}
+	var records [][]byte
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		if field.wireType != protobufBytes {
+			return nil
+		}
+
+		switch field.number {
+		case 1:
+			scope = make(map[string]interface{})
+			return walkProtobuf(field.data, func(field protobufField) error {
+				if field.wireType != protobufBytes {
+					return nil
+				}
+				switch field.number {
+				case 1:
+					scope["name"] = string(field.data)
+				case 2:
+					scope["version"] = string(field.data)
+				case 3:
+					attributes, _ := scope["attributes"].(map[string]interface{})
+					if attributes == nil {
+						attributes = make(map[string]interface{})
+						scope["attributes"] = attributes
+					}
+					return decodeOTLPKeyValue(field.data, attributes, 0)
+				}
+				return nil
+			})
+		case 2:
+			records = append(records, field.data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]interface{}
+	for _, record := range records {
+		logEntry, err := decodeOTLPLogRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		// every record gets its own copy, injections and outputs may change them
+		if resource != nil {
+			logEntry["resource"] = copyOTLPValue(resource)
+		}
+		if len(scope) > 0 {
+			logEntry["scope"] = copyOTLPValue(scope)
+		}
+		entries = append(entries, logEntry)
+	}
+
+	return entries, nil
+}
+
+// decodeOTLPLogRecord - a LogRecord as an event, a string body becomes the message and
+// any other body is kept as it is in body
+func decodeOTLPLogRecord(data []byte) (map[string]interface{}, error) {
+	logEntry := make(map[string]interface{})
+	var timestamp, observed uint64
+	var severityNumber uint64
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		switch field.number {
+		case 1:
+			timestamp = field.value
+		case 11:
+			observed = field.value
+		case 2:
+			severityNumber = field.value
+		case 3:
+			if len(field.data) > 0 {
+				logEntry["severity"] = string(field.data)
+			}
+		case 5:
+			body, err := decodeOTLPAnyValue(field.data, 0)
+			if err != nil {
+				return err
+			}
+			if message, ok := body.(string); ok {
+				logEntry["message"] = message
+			} else if body != nil {
+				logEntry["body"] = body
+			}
+		case 6:
+			attributes, _ := logEntry["attributes"].(map[string]interface{})
+			if attributes == nil {
+				attributes = make(map[string]interface{})
+				logEntry["attributes"] = attributes
+			}
+			return decodeOTLPKeyValue(field.data, attributes, 0)
+		case 9:
+			if len(field.data) > 0 {
+				logEntry["trace_id"] = hex.EncodeToString(field.data)
+			}
+		case 10:
+			if len(field.data) > 0 {
+				logEntry["span_id"] = hex.EncodeToString(field.data)
+			}
+		case 12:
+			if len(field.data) > 0 {
+				logEntry["event_name"] = string(field.data)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// when the event happened, or failing that when the SDK saw it
+	if timestamp == 0 {
+		timestamp = observed
+	}
+	if timestamp != 0 {
+		logEntry["@timestamp"] = time.Unix(0, int64(timestamp)).UTC().Format(time.RFC3339Nano)
+	} else {
+		logEntry["@timestamp"] = get3339Time()
+	}
+
+	if severityNumber != 0 {
+		logEntry["severity_number"] = int64(severityNumber)
+		if _, ok := logEntry["severity"]; !ok {
+			logEntry["severity"] = otlpSeverityText(severityNumber)
+		}
+	}
+
+	return logEntry, nil
+}
+
+// otlpSeverityText - the short name of a SeverityNumber, for records that only set the number
+func otlpSeverityText(number uint64) string {
+	names := []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
+	if number < 1 || number > 24 {
+		return "UNSPECIFIED"
+	}
+
+	return names[(number-1)/4]
+}
+
+// decodeOTLPKeyValue - adds a KeyValue to values
+func decodeOTLPKeyValue(data []byte, values map[string]interface{}, depth int) error {
+	var key string
+	var value interface{}
+
+	err := walkProtobuf(data, func(field protobufField) error {
+		if field.wireType != protobufBytes {
+			return nil
+		}
+
+		switch field.number {
+		case 1:
+			key = string(field.data)
+		case 2:
+			var err error
+			value, err = decodeOTLPAnyValue(field.data, depth)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	values[key] = value
+	return nil
+}
+
+// decodeOTLPAnyValue - an AnyValue as the type encoding/json would decode it into,
+// bytes stay []byte and so are base64 encoded when the event is marshalled
+func decodeOTLPAnyValue(data []byte, depth int) (interface{}, error) {
+	if depth > otlpMaxDepth {
+		return nil, fmt.Errorf("values nested more than %d deep", otlpMaxDepth)
+	}
+
+	var value interface{}
+	err := walkProtobuf(data, func(field protobufField) error {
+		if wireType, ok := otlpAnyValueWireTypes[field.number]; ok && field.wireType != wireType {
+			return fmt.Errorf("AnyValue field %d has wire type %d", field.number, field.wireType)
+		}
+
+		switch field.number {
+		case 1:
+			value = string(field.data)
+		case 2:
+			value = field.value != 0
+		case 3:
+			value = int64(field.value)
+		case 4:
+			value = math.Float64frombits(field.value)
+		case 5:
+			values := []interface{}{}
+			err := walkProtobuf(field.data, func(field protobufField) error {
+				if field.number != 1 || field.wireType != protobufBytes {
+					return nil
+				}
+				item, err := decodeOTLPAnyValue(field.data, depth+1)
+				values = append(values, item)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			value = values
+		case 6:
+			values := make(map[string]interface{})
+			err := walkProtobuf(field.data, func(field protobufField) error {
+				if field.number != 1 || field.wireType != protobufBytes {
+					return nil
+				}
+				return decodeOTLPKeyValue(field.data, values, depth+1)
+			})
+			if err != nil {
+				return err
+			}
+			value = values
+		case 7:
+			value = field.data
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+// copyOTLPValue - a deep copy of the maps and arrays in value
+func copyOTLPValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			copied[key] = copyOTLPValue(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyOTLPValue(item)
+		}
+		return copied
+	}
+
+	return value
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func otlpString(value string) []byte {
+	return protobufBytesField(1, []byte(value))
+}
+
+func otlpKeyValue(key string, value []byte) []byte {
+	return protobufMessage(protobufBytesField(1, []byte(key)), protobufBytesField(2, value))
+}
+
+// otlpRequest - an ExportLogsServiceRequest with one resource and scope holding records
+func otlpRequest(records ...[]byte) []byte {
+	scope := protobufBytesField(1, protobufBytesField(1, []byte("lib")), protobufBytesField(2, []byte("1.0")))
+	var scopeLogs []byte
+	scopeLogs = append(scopeLogs, scope...)
+	for _, record := range records {
+		scopeLogs = append(scopeLogs, protobufBytesField(2, record)...)
+	}
+
+	resource := protobufBytesField(1, protobufBytesField(1, otlpKeyValue("service.name", otlpString("api"))))
+	return protobufBytesField(1, resource, protobufBytesField(2, scopeLogs))
+}
+
+// otlpNestedArray - an AnyValue holding arrays depth deep
+func otlpNestedArray(depth int) []byte {
+	value := otlpString("bottom")
+	for i := 0; i < depth; i++ {
+		value = protobufBytesField(5, protobufBytesField(1, value))
+	}
+	return value
+}
+
+func TestDecodeOTLPLogs(t *testing.T) {
+	resource := map[string]interface{}{"service.name": "api"}
+	scope := map[string]interface{}{"name": "lib", "version": "1.0"}
+
+	tests := []struct {
+		name    string
+		request []byte
+		want    []map[string]interface{}
+		err     bool
+	}{
+		{name: "empty"},
+		{
+			name: "string body",
+			request: otlpRequest(protobufMessage(
+				protobufFixed64Field(1, 1700000000123456789),
+				protobufVarintField(2, 9),
+				protobufBytesField(5, otlpString("hello")),
+				protobufBytesField(6, otlpKeyValue("count", protobufVarintField(3, 3))),
+				protobufBytesField(9, []byte{0xab, 0xcd}),
+			)),
+			want: []map[string]interface{}{{
+				"@timestamp": "2023-11-14T22:13:20.123456789Z", "severity": "INFO", "severity_number": int64(9),
+				"message": "hello", "attributes": map[string]interface{}{"count": int64(3)}, "trace_id": "abcd",
+				"resource": resource, "scope": scope,
+			}},
+		},
+		{
+			name: "structured body and observed time",
+			request: otlpRequest(protobufMessage(
+				protobufFixed64Field(11, 1000000000),
+				protobufBytesField(3, []byte("Warning")),
+				protobufVarintField(2, 13),
+				protobufBytesField(5, protobufBytesField(6, protobufBytesField(1, otlpKeyValue("ok", protobufVarintField(2, 1))))),
+			)),
+			want: []map[string]interface{}{{
+				"@timestamp": "1970-01-01T00:00:01Z", "severity": "Warning", "severity_number": int64(13),
+				"body": map[string]interface{}{"ok": true}, "resource": resource, "scope": scope,
+			}},
+		},
+		{name: "nested past the limit", request: otlpRequest(protobufBytesField(5, otlpNestedArray(otlpMaxDepth+2))), err: true},
+		{name: "body with the wrong wire type", request: otlpRequest(protobufBytesField(5, protobufVarintField(1, 5))), err: true},
+		{name: "truncated request", request: otlpRequest(protobufBytesField(5, otlpString("hello")))[:10], err: true},
+		{name: "truncated record", request: otlpRequest([]byte{5<<3 | protobufBytes, 40}), err: true},
+	}
+
+	for _, test := range tests {
+		got, err := decodeOTLPLogs(test.request)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: decoded %v, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := decodeOTLPLogs(otlpRequest(protobufBytesField(5, otlpNestedArray(otlpMaxDepth)))); err != nil {
+		t.Errorf("values nested to the limit: %v", err)
+	}
+}
+
+func TestOTLPReceiverClosed(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+	receiver := &otlpReceiver{
+		ctx:     context.Background(),
+		input:   FlingInOTLP{Listen: ":0", Outputs: []string{"out"}},
+		outputs: map[string]interface{}{"out": queue},
+	}
+	request := otlpRequest(protobufBytesField(5, otlpString("hello")))
+
+	if _, err := receiver.export(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	receiver.close()
+	if _, err := receiver.export(context.Background(), request); status.Code(err) != codes.Unavailable {
+		t.Errorf("export after close returned %v, want Unavailable", err)
+	}
+	if len(queue.channel) != 1 {
+		t.Errorf("%d events dispatched, want 1", len(queue.channel))
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// what a parser does with a line that isn't in its format
+const (
+	noMatchKeep = "keep"
+	noMatchDrop = "drop"
+	noMatchTag  = "tag"
+)
+
+//FlingParser - turns each line of a file that isn't JSON into event fields
+type FlingParser struct {
+	Type            string            `json:"type"`                    // regex, grok, logfmt, nginx or apache
+	Pattern         string            `json:"pattern,omitempty"`       // regex: its named groups become fields, grok: tried before patterns
+	Patterns        []string          `json:"patterns,omitempty"`      // grok: tried in order until one matches
+	PatternFiles    []string          `json:"pattern_files,omitempty"` // grok: files of "NAME pattern" lines adding to the bundled patterns
+	PatternDefs     map[string]string `json:"pattern_definitions,omitempty"`
+	PairSeparator   string            `json:"pair_separator,omitempty"`   // logfmt: between pairs, default any whitespace
+	ValueSeparator  string            `json:"value_separator,omitempty"`  // logfmt: between a key and its value, default =
+	CoerceNumbers   bool              `json:"coerce_numbers,omitempty"`   // logfmt: unquoted numbers become ints or floats
+	CoerceDurations bool              `json:"coerce_durations,omitempty"` // logfmt: unquoted durations such as 12ms become float seconds
+	Format          string            `json:"format,omitempty"`           // nginx: combined (default) or main, apache: common or combined (default)
+	LogFormat       string            `json:"log_format,omitempty"`       // nginx: a log_format to derive the parser from instead
+	Types           map[string]string `json:"types,omitempty"`            // field to int, float, bool, timestamp or timestamp:<layout>
+	TimestampField  string            `json:"timestamp_field,omitempty"`  // field holding the event's time, used for @timestamp
+	OnNoMatch       string            `json:"on_no_match,omitempty"`      // keep (default), drop or tag
+	NoMatchTag      string            `json:"no_match_tag,omitempty"`     // added to tags when on_no_match is tag, default _parse_failure
+
+	once   sync.Once
+	parser lineParser
+}
+
+// lineParser - the fields in a line, false if the line isn't in the parser's format
+type lineParser interface {
+	parse(line string) (map[string]interface{}, bool)
+}
+
+// layouts a timestamp type hint without a layout is tried against, in order
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05,999999999",
+	"02/Jan/2006:15:04:05 -0700",
+	"2006/01/02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.UnixDate,
+	time.ANSIC,
+	time.Stamp,
+}
+
+func (config *FlingParser) onNoMatch() string {
+	if config.OnNoMatch == "" {
+		return noMatchKeep
+	}
+
+	return config.OnNoMatch
+}
+
+func (config *FlingParser) noMatchTag() string {
+	if config.NoMatchTag == "" {
+		return "_parse_failure"
+	}
+
+	return config.NoMatchTag
+}
+
+// newLineParser - the parser the config describes
+func (config *FlingParser) newLineParser() (lineParser, error) {
+	switch config.Type {
+	case "regex":
+		return newRegexParser(config.Pattern)
+	case "grok":
+		patterns := config.Patterns
+		if config.Pattern != "" {
+			patterns = append([]string{config.Pattern}, patterns...)
+		}
+		return newGrokParser(patterns, config.PatternFiles, config.PatternDefs)
+	case "logfmt":
+		return newLogfmtParser(config)
+	case "nginx", "apache":
+		return newAccessLogParser(config.Type, config.Format, config.LogFormat)
+	}
+
+	return nil, fmt.Errorf("unknown parser type %q", config.Type)
+}
+
+// parseLine - the event for line, nil if it should be dropped
+func (config *FlingParser) parseLine(line string, path string) map[string]interface{} {
+	config.once.Do(func() {
+		var err error
+		if config.parser, err = config.newLineParser(); err != nil {
+			// only reachable if validation missed something, every line is a no match
+			log.WithFields(log.Fields{
+				"path":  path,
+				"error": err,
+			}).Error("Couldn't create parser")
+		}
+	})
+
+	var logEntry map[string]interface{}
+	matched := false
+	if config.parser != nil {
+		logEntry, matched = config.parser.parse(line)
+	}
+
+	if !matched {
+		countMetric(parseFailures, inputKey, path)
+
+		switch config.onNoMatch() {
+		case noMatchDrop:
+			return nil
+		case noMatchTag:
+			return map[string]interface{}{"message": line, "tags": []interface{}{config.noMatchTag()}}
+		default:
+			return map[string]interface{}{"message": line}
+		}
+	}
+
+	if _, ok := logEntry["message"]; !ok {
+		logEntry["message"] = line
+	}
+	config.convertTypes(logEntry, path)
+
+	return logEntry
+}
+
+// convertTypes - applies the type hints to the fields the parser found, a value that
+// doesn't convert is left as it is
+func (config *FlingParser) convertTypes(logEntry map[string]interface{}, path string) {
+	for field, hint := range config.Types {
+		text, ok := logEntry[field].(string)
+		if !ok {
+			continue
+		}
+
+		value, err := convertField(text, hint)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  path,
+				"field": field,
+				"value": text,
+				"error": err,
+			}).Debug("Couldn't convert field")
+			continue
+		}
+		logEntry[field] = value
+	}
+
+	if config.TimestampField == "" {
+		return
+	}
+
+	// already converted if it has a timestamp type hint, which keeps its layout
+	if value, ok := logEntry[config.TimestampField].(string); ok {
+		if eventTime, err := parseTimestamp(value, ""); err == nil {
+			logEntry["@timestamp"] = eventTime.UTC().Format(time.RFC3339Nano)
+		}
+	}
+}
+
+// convertField - text as the type hint asks, timestamps become RFC 3339 strings
+func convertField(text string, hint string) (interface{}, error) {
+	switch {
+	case hint == "int":
+		return strconv.ParseInt(text, 10, 64)
+	case hint == "float":
+		return strconv.ParseFloat(text, 64)
+	case hint == "bool":
+		return strconv.ParseBool(text)
+	case hint == "timestamp" || strings.HasPrefix(hint, "timestamp:"):
+		eventTime, err := parseTimestamp(text, strings.TrimPrefix(strings.TrimPrefix(hint, "timestamp"), ":"))
+		if err != nil {
+			return nil, err
+		}
+		return eventTime.UTC().Format(time.RFC3339Nano), nil
+	}
+
+	return nil, fmt.Errorf("unknown type %q", hint)
+}
+
+// parseTimestamp - text in layout, or in any of the common layouts when layout is empty
+func parseTimestamp(text string, layout string) (time.Time, error) {
+	if layout != "" {
+		return time.Parse(layout, text)
+	}
+
+	for _, layout := range timestampLayouts {
+		if eventTime, err := time.Parse(layout, text); err == nil {
+			if eventTime.Year() == 0 {
+				// syslog style stamps leave the year out
+				eventTime = eventTime.AddDate(time.Now().Year(), 0, 0)
+			}
+			return eventTime, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised time %q", text)
+}
+
+// validTypeHint - whether hint is one convertField understands
+func validTypeHint(hint string) bool {
+	switch {
+	case hint == "int", hint == "float", hint == "bool", hint == "timestamp":
+		return true
+	case strings.HasPrefix(hint, "timestamp:"):
+		return len(hint) > len("timestamp:")
+	}
+
+	return false
+}
+
+// regexParser - the named groups of a regular expression, which is unanchored unless
+// it starts with ^ or ends with $
+type regexParser struct {
+	pattern *regexp.Regexp
+}
+
+func newRegexParser(pattern string) (lineParser, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("regex parser has no pattern")
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Join(compiled.SubexpNames(), "") == "" {
+		return nil, fmt.Errorf("regex parser pattern has no named groups")
+	}
+
+	return &regexParser{pattern: compiled}, nil
+}
+
+func (parser *regexParser) parse(line string) (map[string]interface{}, bool) {
+	match := parser.pattern.FindStringSubmatchIndex(line)
+	if match == nil {
+		return nil, false
+	}
+
+	fields := make(map[string]interface{})
+	for i, name := range parser.pattern.SubexpNames() {
+		// optional groups that didn't take part in the match are left out
+		if name == "" || match[2*i] < 0 {
+			continue
+		}
+		fields[name] = line[match[2*i]:match[2*i+1]]
+	}
+
+	return fields, true
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRegexParser(t *testing.T) {
+	tests := []struct {
+		pattern string
+		err     bool
+	}{
+		{`(?P<level>\w+)`, false},
+		{"", true},
+		{`(\w+)`, true},
+		{`(?P<level>\w+`, true},
+	}
+
+	for _, test := range tests {
+		if _, err := newRegexParser(test.pattern); (err != nil) != test.err {
+			t.Errorf("%q: error %v, want error %v", test.pattern, err, test.err)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	pattern := `^(?P<time>\S+) (?P<level>[A-Z]+)(?: took=(?P<took>\S+))? (?P<msg>.*)$`
+
+	tests := []struct {
+		name   string
+		config *FlingParser
+		line   string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "match",
+			config: &FlingParser{Type: "regex", Pattern: pattern},
+			line:   "2024-03-01T12:00:00Z INFO started",
+			want:   map[string]interface{}{"time": "2024-03-01T12:00:00Z", "level": "INFO", "msg": "started", "message": "2024-03-01T12:00:00Z INFO started"},
+		},
+		{
+			name: "types and timestamp field",
+			config: &FlingParser{
+				Type: "regex", Pattern: pattern, TimestampField: "time",
+				Types: map[string]string{"took": "float", "level": "int"},
+			},
+			line: "2024-03-01T13:00:00+01:00 WARN took=1.5 slow",
+			want: map[string]interface{}{
+				"time": "2024-03-01T13:00:00+01:00", "level": "WARN", "took": 1.5, "msg": "slow",
+				"@timestamp": "2024-03-01T12:00:00Z", "message": "2024-03-01T13:00:00+01:00 WARN took=1.5 slow",
+			},
+		},
+		{
+			name:   "timestamp type hint",
+			config: &FlingParser{Type: "regex", Pattern: pattern, Types: map[string]string{"time": "timestamp:2006-01-02T15:04:05Z07:00"}},
+			line:   "2024-03-01T13:00:00+01:00 INFO x",
+			want:   map[string]interface{}{"time": "2024-03-01T12:00:00Z", "level": "INFO", "msg": "x", "message": "2024-03-01T13:00:00+01:00 INFO x"},
+		},
+		{
+			name:   "no match kept",
+			config: &FlingParser{Type: "regex", Pattern: pattern},
+			line:   "garbage",
+			want:   map[string]interface{}{"message": "garbage"},
+		},
+		{
+			name:   "no match dropped",
+			config: &FlingParser{Type: "regex", Pattern: pattern, OnNoMatch: noMatchDrop},
+			line:   "garbage",
+		},
+		{
+			name:   "no match tagged",
+			config: &FlingParser{Type: "regex", Pattern: pattern, OnNoMatch: noMatchTag},
+			line:   "garbage",
+			want:   map[string]interface{}{"message": "garbage", "tags": []interface{}{"_parse_failure"}},
+		},
+		{
+			name:   "parser that can't be created",
+			config: &FlingParser{Type: "regex", Pattern: `(`, OnNoMatch: noMatchTag, NoMatchTag: "bad"},
+			line:   "anything",
+			want:   map[string]interface{}{"message": "anything", "tags": []interface{}{"bad"}},
+		},
+	}
+
+	for _, test := range tests {
+		got := test.config.parseLine(test.line, "test.log")
+		if test.want == nil {
+			if got != nil {
+				t.Errorf("%s: got %v, want the line dropped", test.name, got)
+			}
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConvertField(t *testing.T) {
+	tests := []struct {
+		text string
+		hint string
+		want interface{}
+		err  bool
+	}{
+		{"42", "int", int64(42), false},
+		{"-7", "int", int64(-7), false},
+		{"4.2", "int", nil, true},
+		{"99999999999999999999", "int", nil, true},
+		{"4.2", "float", 4.2, false},
+		{"NaN?", "float", nil, true},
+		{"true", "bool", true, false},
+		{"yes", "bool", nil, true},
+		{"2024-03-01 12:00:00,5", "timestamp", "2024-03-01T12:00:00.5Z", false},
+		{"01/03/2024", "timestamp:02/01/2006", "2024-03-01T00:00:00Z", false},
+		{"2024-03-01", "timestamp:02/01/2006", nil, true},
+		{"42", "number", nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := convertField(test.text, test.hint)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q as %s: got %v, want an error", test.text, test.hint, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("%q as %s: got %v %v, want %v", test.text, test.hint, got, err, test.want)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	year := time.Now().Year()
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"2024-03-01T12:00:00.123Z", "2024-03-01T12:00:00.123Z"},
+		{"2024-03-01 12:00:00 +0100", "2024-03-01T11:00:00Z"},
+		{"2024-03-01T12:00:00", "2024-03-01T12:00:00Z"},
+		{"01/Mar/2024:12:00:00 -0500", "2024-03-01T17:00:00Z"},
+		{"2024/03/01 12:00:00", "2024-03-01T12:00:00Z"},
+		{"Fri, 01 Mar 2024 12:00:00 +0000", "2024-03-01T12:00:00Z"},
+		{"Mar  1 12:00:00", fmt.Sprintf("%d-03-01T12:00:00Z", year)},
+		{"yesterday", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		got, err := parseTimestamp(test.text, "")
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", test.text, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.text, err)
+		} else if formatted := got.UTC().Format(time.RFC3339Nano); formatted != test.want {
+			t.Errorf("%q: got %s, want %s", test.text, formatted, test.want)
+		}
+	}
+}
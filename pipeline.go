@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// workerGroup - the goroutines started for one configured input, output or
+// rotation, so that one can be stopped without touching the rest
+type workerGroup struct {
+	name    string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+
+	// set when one of its goroutines returned without the group being cancelled
+	died int32
+}
+
+func newWorkerGroup(name string) *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{name: name, ctx: ctx, cancel: cancel}
+}
+
+// start - runs worker in a goroutine belonging to the group
+func (group *workerGroup) start(worker func()) {
+	group.workers.Add(1)
+	go func() {
+		defer group.workers.Done()
+		worker()
+
+		if group.ctx.Err() == nil {
+			atomic.StoreInt32(&group.died, 1)
+			log.WithFields(log.Fields{"worker": group.name}).Error("Worker exited unexpectedly")
+		}
+	}()
+}
+
+func (group *workerGroup) hasDied() bool {
+	return atomic.LoadInt32(&group.died) == 1
+}
+
+// waitUntil - waits for every goroutine in the group, false if deadline passed first
+func (group *workerGroup) waitUntil(deadline time.Time) bool {
+	done := make(chan bool)
+	go func() {
+		group.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// configuredOutput - one output from the config and how to start its worker
+type configuredOutput struct {
+	name   string
+	key    string // the output's config, a change means restarting it
+	queue  FlingOutputQueue
+	worker func(ctx context.Context, channel chan FlingEvent) // ctx is cancelled when the output stops
+}
+
+// configuredWorker - one input or rotation from the config and how to start it
+type configuredWorker struct {
+	name    string   // how health checks and logs refer to it
+	key     string   // the worker's config, a change means restarting it
+	outputs []string // outputs it dispatches to, it is restarted if any of them are
+	start   func(group *workerGroup, outputs map[string]interface{})
+}
+
+type runningOutput struct {
+	key   string
+	queue *outputQueue
+	group *workerGroup
+}
+
+type runningWorker struct {
+	configuredWorker
+	group *workerGroup
+}
+
+// pipeline - everything running for the current config, apply moves it to a
+// new config restarting only what changed
+type pipeline struct {
+	lock      sync.Mutex
+	config    FlingConfig
+	outputs   map[string]*runningOutput
+	channels  map[string]interface{}
+	inputs    map[string]*runningWorker
+	rotations map[string]*runningWorker
+
+	// replaced outputs still draining what was queued before a reload
+	retired []*workerGroup
+
+	// a *pipelineStatus, read by health checks and metrics without waiting on lock,
+	// which apply and shutdown hold while workers stop
+	status atomic.Value
+}
+
+// pipelineStatus - what is running, as of the last time apply finished
+type pipelineStatus struct {
+	channels map[string]interface{}
+	groups   []*workerGroup
+}
+
+func newPipeline() *pipeline {
+	pipe := &pipeline{
+		outputs:   make(map[string]*runningOutput),
+		channels:  make(map[string]interface{}),
+		inputs:    make(map[string]*runningWorker),
+		rotations: make(map[string]*runningWorker),
+	}
+	pipe.publishStatus()
+
+	return pipe
+}
+
+// configKey - identifies a piece of config so unchanged parts can be left running
+func configKey(kind string, config interface{}) string {
+	encoded, _ := json.Marshal(config)
+	return kind + ":" + string(encoded)
+}
+
+func (pipe *pipeline) currentChannels() map[string]interface{} {
+	return pipe.currentStatus().channels
+}
+
+func (pipe *pipeline) currentStatus() *pipelineStatus {
+	return pipe.status.Load().(*pipelineStatus)
+}
+
+// publishStatus - snapshots what is running, called with lock held
+func (pipe *pipeline) publishStatus() {
+	status := &pipelineStatus{channels: pipe.channels}
+	for _, running := range pipe.outputs {
+		status.groups = append(status.groups, running.group)
+	}
+	for _, worker := range pipe.inputs {
+		status.groups = append(status.groups, worker.group)
+	}
+	for _, worker := range pipe.rotations {
+		status.groups = append(status.groups, worker.group)
+	}
+
+	pipe.status.Store(status)
+}
+
+func (pipe *pipeline) apply(config FlingConfig) {
+	pipe.lock.Lock()
+	defer pipe.lock.Unlock()
+
+	if pipe.config.Registry != config.Registry && len(pipe.outputs) > 0 {
+		log.Warn("Registry settings changed, they take effect on the next restart")
+	}
+	if pipe.config.Monitoring != config.Monitoring && len(pipe.outputs) > 0 {
+		log.Warn("Monitoring settings changed, they take effect on the next restart")
+	}
+
+	wantedOutputs := make(map[string]configuredOutput)
+	for _, output := range handleOutputs(config.Output) {
+		wantedOutputs[output.name] = output
+	}
+
+	// outputs that are going away or being restarted with new settings
+	replaced := make(map[string]bool)
+	for name, running := range pipe.outputs {
+		if wanted, ok := wantedOutputs[name]; !ok || wanted.key != running.key {
+			replaced[name] = true
+		}
+	}
+
+	// inputs have to stop before the outputs they send to can be closed
+	wantedInputs := keyedWorkers(handleInputs(config.Input))
+	wantedRotations := keyedWorkers(handleRotations(config.Rotations))
+	stopped := pipe.stopWorkers(pipe.inputs, wantedInputs, replaced, deadlineFor(config))
+	pipe.stopWorkers(pipe.rotations, wantedRotations, nil, time.Now())
+
+	channels := make(map[string]interface{})
+	for name, running := range pipe.outputs {
+		if !replaced[name] {
+			channels[name] = running.queue
+		}
+	}
+	retiring := make(map[string]*runningOutput)
+	for name := range replaced {
+		retiring[name] = pipe.outputs[name]
+		delete(pipe.outputs, name)
+	}
+
+	// nothing may dead letter to a replaced output once it has been closed
+	unchanged := make(map[string]interface{})
+	for name, channel := range channels {
+		unchanged[name] = channel
+	}
+	bindDeadLetterOutputs(unchanged)
+
+	// a replacement spools to the same directory, so it can't start until the spool
+	// it is replacing has let go of it
+	unspooled := make(map[string]bool)
+	for name, running := range retiring {
+		log.WithFields(log.Fields{"OutputName": name}).Info("Stopping output")
+		running.group.cancel()
+		if stopped {
+			running.queue.close()
+		} else {
+			// something may still be blocked sending to it, leave it open rather than panic that sender
+			log.WithFields(log.Fields{"OutputName": name}).Error("Inputs didn't stop in time, leaving replaced output open")
+		}
+		pipe.retired = append(pipe.retired, running.group)
+
+		if running.queue.released != nil && !(stopped && waitForRelease(running.queue.released, deadlineFor(config))) {
+			unspooled[name] = true
+		}
+	}
+
+	for name, output := range wantedOutputs {
+		if _, running := pipe.outputs[name]; running {
+			continue
+		}
+
+		if unspooled[name] && output.queue.Spool != nil {
+			log.WithFields(log.Fields{"OutputName": name}).Error("Replaced output is still using its spool, starting without one until the output is next restarted")
+			output.queue.Spool = nil
+		}
+
+		log.WithFields(log.Fields{"OutputName": name}).Info("Starting output")
+		running := startOutput(output)
+		pipe.outputs[name] = running
+		channels[name] = running.queue
+	}
+	pipe.channels = channels
+	bindDeadLetterOutputs(channels)
+
+	pipe.startWorkers(pipe.inputs, wantedInputs, channels)
+	pipe.startWorkers(pipe.rotations, wantedRotations, channels)
+
+	pipe.config = config
+	pipe.publishStatus()
+}
+
+// waitForRelease - waits for a spool to let go of its directory, false if deadline passed first
+func waitForRelease(released chan bool, deadline time.Time) bool {
+	select {
+	case <-released:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+func keyedWorkers(workers []configuredWorker) map[string]configuredWorker {
+	keyed := make(map[string]configuredWorker)
+	for _, worker := range workers {
+		keyed[worker.key] = worker
+	}
+
+	return keyed
+}
+
+// stopWorkers - stops running workers that aren't wanted any more or that send to
+// a replaced output, returns false if they didn't all stop before deadline
+func (pipe *pipeline) stopWorkers(running map[string]*runningWorker, wanted map[string]configuredWorker, replaced map[string]bool, deadline time.Time) bool {
+	var stopping []*workerGroup
+
+	for key, worker := range running {
+		_, keep := wanted[key]
+		for _, output := range worker.outputs {
+			if replaced[output] {
+				keep = false
+			}
+		}
+		if keep {
+			continue
+		}
+
+		worker.group.cancel()
+		stopping = append(stopping, worker.group)
+		delete(running, key)
+	}
+
+	stopped := true
+	for _, group := range stopping {
+		stopped = group.waitUntil(deadline) && stopped
+	}
+
+	return stopped
+}
+
+func (pipe *pipeline) startWorkers(running map[string]*runningWorker, wanted map[string]configuredWorker, channels map[string]interface{}) {
+	for key, worker := range wanted {
+		if _, exists := running[key]; exists {
+			continue
+		}
+
+		group := newWorkerGroup(worker.name)
+		worker.start(group, channels)
+		running[key] = &runningWorker{configuredWorker: worker, group: group}
+	}
+}
+
+// startOutput - starts an output's worker behind its overflow policy
+func startOutput(output configuredOutput) *runningOutput {
+	group := newWorkerGroup("output " + output.name)
+	channel := make(chan FlingEvent, output.queue.bufferSize())
+	resetOutputHealth(output.name)
+	group.start(func() { output.worker(group.ctx, channel) })
+
+	return &runningOutput{
+		key:   output.key,
+		queue: newOutputQueue(output.name, output.queue, channel, group),
+		group: group,
+	}
+}
+
+func deadlineFor(config FlingConfig) time.Time {
+	timeout := config.ShutdownTimeout
+	if timeout == 0 {
+		//leave headroom inside kubernetes' default 30 second grace period
+		timeout = 20
+	}
+
+	return time.Now().Add(time.Duration(timeout) * time.Second)
+}
+
+// shutdown - stops all inputs and rotations, drains every output and persists
+// offsets, returns false if that couldn't be done before the shutdown timeout
+func (pipe *pipeline) shutdown() bool {
+	pipe.lock.Lock()
+	defer pipe.lock.Unlock()
+
+	deadline := deadlineFor(pipe.config)
+	drained := true
+
+	var inputs []*workerGroup
+	for _, worker := range pipe.inputs {
+		worker.group.cancel()
+		inputs = append(inputs, worker.group)
+	}
+	for _, worker := range pipe.rotations {
+		worker.group.cancel()
+		inputs = append(inputs, worker.group)
+	}
+
+	for _, group := range inputs {
+		if !group.waitUntil(deadline) {
+			log.Error("Inputs did not stop before the shutdown deadline")
+			drained = false
+			break
+		}
+	}
+
+	if drained {
+		// nothing can dispatch any more, closing lets each output finish its backlog and return,
+		// dead letters raised while draining are logged rather than routed to a closing output
+		bindDeadLetterOutputs(nil)
+
+		outputs := pipe.retired
+		for _, running := range pipe.outputs {
+			running.group.cancel()
+			running.queue.close()
+			outputs = append(outputs, running.group)
+		}
+
+		for _, group := range outputs {
+			if !group.waitUntil(deadline) {
+				log.Error("Outputs did not drain before the shutdown deadline")
+				drained = false
+				break
+			}
+		}
+	}
+
+	if flushErr := registry.flush(); flushErr != nil {
+		log.WithFields(log.Fields{
+			"path":  registry.path,
+			"error": flushErr,
+		}).Error("Couldn't write registry")
+		drained = false
+	}
+
+	if drained {
+		log.Info("Shutdown complete")
+	}
+
+	return drained
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadReleasesSpoolBeforeReplacing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := func(bufferSize int) FlingConfig {
+		queue := FlingOutputQueue{BufferSize: bufferSize, Spool: &FlingSpool{Path: dir}}
+		return FlingConfig{Output: FlingOutput{Loggers: []FlingOutLogger{{Name: "out", FlingOutputQueue: queue}}}}
+	}
+
+	pipe := newPipeline()
+	pipe.apply(config(10))
+	replaced := pipe.outputs["out"]
+	if replaced.queue.released == nil {
+		t.Fatal("output isn't spooling")
+	}
+
+	pipe.apply(config(20))
+	select {
+	case <-replaced.queue.released:
+	default:
+		t.Error("replacement started while the replaced spool was still open")
+	}
+	if replacement := pipe.outputs["out"]; replacement == replaced || replacement.queue.released == nil {
+		t.Error("output wasn't replaced by one that spools")
+	}
+
+	if !pipe.shutdown() {
+		t.Error("shutdown didn't drain")
+	}
+}
+
+func TestShutdownDrainsAndFlushesRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(previous *offsetRegistry) { registry = previous }(registry)
+	registryConfig := FlingRegistry{Path: filepath.Join(dir, "registry.json")}
+	registry = newOffsetRegistry(registryConfig.Path)
+
+	logPath := filepath.Join(dir, "app.log")
+	lines := "one\ntwo\nthree\n"
+	writeTestFile(t, logPath, lines)
+
+	pipe := newPipeline()
+	pipe.apply(FlingConfig{
+		Input:  FlingInput{Files: []FlingInFile{{Path: logPath, StartPosition: "beginning", Outputs: []string{"out"}}}},
+		Output: FlingOutput{Loggers: []FlingOutLogger{{Name: "out", FlingOutputQueue: FlingOutputQueue{BufferSize: 10}}}},
+	})
+	queue := pipe.outputs["out"].queue
+
+	// every line has been dispatched once its offset is committed
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if offset, _ := registry.resumeOffset(logPath); offset == int64(len(lines)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lines weren't read")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !pipe.shutdown() {
+		t.Fatal("shutdown didn't drain")
+	}
+	if len(queue.channel) != 0 {
+		t.Errorf("%d events left in the output after shutdown", len(queue.channel))
+	}
+
+	loaded, err := loadRegistry(registryConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := loaded.entries[logPath]; entry == nil || entry.Offset != int64(len(lines)) {
+		t.Errorf("registry on disk has %+v after shutdown, want offset %d", entry, len(lines))
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// protobuf wire types
+const (
+	protobufVarint  = 0
+	protobufFixed64 = 1
+	protobufBytes   = 2
+	protobufFixed32 = 5
+)
+
+// protobufField - one field of an encoded message, value holds varints and fixed width
+// numbers, data the contents of length delimited fields
+type protobufField struct {
+	number   int
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+// walkProtobuf - calls visit with each field of an encoded protobuf message, enough of the
+// wire format to read messages we have no generated code for
+func walkProtobuf(data []byte, visit func(field protobufField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("protobuf: invalid field key")
+		}
+		data = data[n:]
+
+		field := protobufField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case protobufVarint:
+			field.value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("protobuf: invalid varint in field %d", field.number)
+			}
+			data = data[n:]
+		case protobufFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("protobuf: truncated field %d", field.number)
+			}
+			field.value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case protobufFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("protobuf: truncated field %d", field.number)
+			}
+			field.value = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case protobufBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return fmt.Errorf("protobuf: truncated field %d", field.number)
+			}
+			field.data = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			// groups have been deprecated since proto2, nothing we read uses them
+			return fmt.Errorf("protobuf: unsupported wire type %d in field %d", field.wireType, field.number)
+		}
+
+		if err := visit(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
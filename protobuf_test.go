@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func appendUvarint(data []byte, value uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(data, buf[:binary.PutUvarint(buf, value)]...)
+}
+
+// protobufKey - the encoded key of a field
+func protobufKey(number int, wireType int) []byte {
+	return appendUvarint(nil, uint64(number<<3|wireType))
+}
+
+func protobufVarintField(number int, value uint64) []byte {
+	return appendUvarint(protobufKey(number, protobufVarint), value)
+}
+
+func protobufFixed64Field(number int, value uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, value)
+	return append(protobufKey(number, protobufFixed64), buf...)
+}
+
+func protobufBytesField(number int, data ...[]byte) []byte {
+	var contents []byte
+	for _, part := range data {
+		contents = append(contents, part...)
+	}
+	field := appendUvarint(protobufKey(number, protobufBytes), uint64(len(contents)))
+	return append(field, contents...)
+}
+
+func protobufMessage(fields ...[]byte) []byte {
+	var message []byte
+	for _, field := range fields {
+		message = append(message, field...)
+	}
+	return message
+}
+
+func TestWalkProtobuf(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		fields []protobufField
+		err    bool
+	}{
+		{name: "empty"},
+		{
+			name: "every wire type",
+			data: protobufMessage(
+				protobufVarintField(1, 300),
+				protobufFixed64Field(2, 7),
+				[]byte{3<<3 | protobufFixed32, 1, 0, 0, 0},
+				protobufBytesField(4, []byte("hi")),
+			),
+			fields: []protobufField{
+				{number: 1, wireType: protobufVarint, value: 300},
+				{number: 2, wireType: protobufFixed64, value: 7},
+				{number: 3, wireType: protobufFixed32, value: 1},
+				{number: 4, wireType: protobufBytes, data: []byte("hi")},
+			},
+		},
+		{name: "truncated key", data: []byte{0x80}, err: true},
+		{name: "truncated varint", data: []byte{1 << 3, 0x80, 0x80}, err: true},
+		{name: "overlong varint", data: append([]byte{1 << 3}, []byte("\xff\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01")...), err: true},
+		{name: "truncated fixed64", data: []byte{1<<3 | protobufFixed64, 1, 2, 3}, err: true},
+		{name: "truncated fixed32", data: []byte{1<<3 | protobufFixed32, 1}, err: true},
+		{name: "length past the end", data: []byte{1<<3 | protobufBytes, 5, 'a'}, err: true},
+		{name: "huge length", data: append([]byte{1<<3 | protobufBytes}, []byte("\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01")...), err: true},
+		{name: "group", data: []byte{1<<3 | 3}, err: true},
+	}
+
+	for _, test := range tests {
+		var fields []protobufField
+		err := walkProtobuf(test.data, func(field protobufField) error {
+			fields = append(fields, field)
+			return nil
+		})
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: walked %x, want an error", test.name, test.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(fields, test.fields) {
+			t.Errorf("%s: got %+v, want %+v", test.name, fields, test.fields)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// overflow policies, what dispatching does when an output's buffer is full
+const (
+	overflowBlock      = "block"
+	overflowDropNewest = "drop_newest"
+	overflowDropOldest = "drop_oldest"
+	overflowSpill      = "spill"
+)
+
+//FlingOutputQueue - buffering options shared by every output type
+type FlingOutputQueue struct {
+	BufferSize int         `json:"buffer_size,omitempty"`
+	Overflow   string      `json:"overflow,omitempty"`
+	Spool      *FlingSpool `json:"spool,omitempty"`
+}
+
+// outputQueue - what dispatchers send to for a named output, applies the
+// output's overflow policy so one stalled output can't hold up the others
+type outputQueue struct {
+	name    string
+	policy  string
+	channel chan FlingEvent
+	dropped uint64
+
+	// closed once a spooling output's spool has stopped using its directory
+	released chan bool
+}
+
+func (config FlingOutputQueue) bufferSize() int {
+	if config.BufferSize == 0 {
+		return 1000
+	}
+
+	return config.BufferSize
+}
+
+func (config FlingOutputQueue) policy() string {
+	if config.Overflow == "" {
+		// a configured spool implies the output wants to spill rather than block
+		if config.Spool != nil {
+			return overflowSpill
+		}
+		return overflowBlock
+	}
+
+	return config.Overflow
+}
+
+// newOutputQueue - wraps channel, the channel an output worker reads from,
+// any spool worker needed is started in group
+func newOutputQueue(name string, config FlingOutputQueue, channel chan FlingEvent, group *workerGroup) *outputQueue {
+	queue := &outputQueue{
+		name:    name,
+		policy:  config.policy(),
+		channel: channel,
+	}
+
+	if queue.policy == overflowSpill {
+		if config.Spool == nil {
+			log.WithFields(log.Fields{
+				"OutputName": name,
+			}).Error("Overflow policy spill needs a spool, blocking instead")
+			queue.policy = overflowBlock
+		} else {
+			// the spool worker never leaves its intake full, so sends to it can block safely
+			queue.channel, queue.released = spoolOutput(name, config.Spool, channel, group)
+		}
+	}
+
+	return queue
+}
+
+// send - queues event for the output, false if the overflow policy dropped it instead
+func (queue *outputQueue) send(event FlingEvent) bool {
+	switch queue.policy {
+	case overflowDropNewest:
+		select {
+		case queue.channel <- event:
+		default:
+			queue.drop()
+			return false
+		}
+	case overflowDropOldest:
+		for {
+			select {
+			case queue.channel <- event:
+				return true
+			default:
+			}
+
+			// make room by discarding whatever has waited longest
+			select {
+			case <-queue.channel:
+				queue.drop()
+			default:
+			}
+		}
+	default:
+		queue.channel <- event
+	}
+
+	return true
+}
+
+// sendUnless - send, except that a send blocked on a full output gives up once abort is closed
+func (queue *outputQueue) sendUnless(event FlingEvent, abort <-chan struct{}) bool {
+	if queue.policy == overflowDropNewest || queue.policy == overflowDropOldest {
+		return queue.send(event)
+	}
+
+	// with room for the event and abort closed, select would pick either at random
+	select {
+	case queue.channel <- event:
+		return true
+	default:
+	}
+
+	select {
+	case queue.channel <- event:
+		return true
+	case <-abort:
+		return false
+	}
+}
+
+func (queue *outputQueue) drop() {
+	atomic.AddUint64(&queue.dropped, 1)
+	countMetric(eventsDropped, outputKey, queue.name)
+}
+
+// full - whether a blocking send would have to wait for the output
+func (queue *outputQueue) full() bool {
+	return len(queue.channel) >= cap(queue.channel)
+}
+
+// close - no more events will be sent, the output drains what's buffered and stops
+func (queue *outputQueue) close() {
+	close(queue.channel)
+}
+
+// reportDrops - periodically logs how many events each output discarded
+func reportDrops(pipe *pipeline) {
+	reported := make(map[string]uint64)
+
+	for {
+		time.Sleep(30 * time.Second)
+
+		for name, channel := range pipe.currentChannels() {
+			dropped := atomic.LoadUint64(&channel.(*outputQueue).dropped)
+			if dropped < reported[name] {
+				// the output was replaced by a reload and started counting again
+				reported[name] = 0
+			}
+			if dropped == reported[name] {
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"OutputName": name,
+				"dropped":    dropped - reported[name],
+				"total":      dropped,
+			}).Warn("Output buffer full, events dropped")
+			reported[name] = dropped
+		}
+	}
+}
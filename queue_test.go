@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// receiveTestEvents - the messages of count events read from channel, fewer if they stop arriving
+func receiveTestEvents(channel chan FlingEvent, count int) []string {
+	var messages []string
+	for len(messages) < count {
+		select {
+		case event := <-channel:
+			messages = append(messages, event.JSON["message"].(string))
+		case <-time.After(time.Second):
+			return messages
+		}
+	}
+
+	return messages
+}
+
+func TestOutputQueueOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		policy      string
+		spool       *FlingSpool
+		wantSent    []bool
+		wantKept    []int
+		wantDropped uint64
+	}{
+		{overflowBlock, nil, []bool{true, true, false, false}, []int{0, 1}, 0},
+		{overflowDropNewest, nil, []bool{true, true, false, false}, []int{0, 1}, 2},
+		{overflowDropOldest, nil, []bool{true, true, true, true}, []int{2, 3}, 2},
+		{overflowSpill, &FlingSpool{Path: dir}, []bool{true, true, true, true}, []int{0, 1, 2, 3}, 0},
+	}
+
+	// nothing reads the output until everything has been sent, a blocked send gives up straight away
+	aborted := make(chan struct{})
+	close(aborted)
+
+	for _, test := range tests {
+		group := newWorkerGroup("output " + test.policy)
+		channel := make(chan FlingEvent, 2)
+		queue := newOutputQueue(test.policy, FlingOutputQueue{Overflow: test.policy, Spool: test.spool}, channel, group)
+
+		var sent []bool
+		for n := 0; n < 4; n++ {
+			sent = append(sent, queue.sendUnless(testSpoolEvent(n), aborted))
+		}
+		if !reflect.DeepEqual(sent, test.wantSent) {
+			t.Errorf("%s: sent %v, want %v", test.policy, sent, test.wantSent)
+		}
+
+		var want []string
+		for _, n := range test.wantKept {
+			want = append(want, fmt.Sprintf("event %d", n))
+		}
+		if kept := receiveTestEvents(channel, len(want)); !reflect.DeepEqual(kept, want) {
+			t.Errorf("%s: output got %v, want %v", test.policy, kept, want)
+		}
+		if len(channel) != 0 {
+			t.Errorf("%s: %d more events than expected reached the output", test.policy, len(channel))
+		}
+		if queue.dropped != test.wantDropped {
+			t.Errorf("%s: dropped %d, want %d", test.policy, queue.dropped, test.wantDropped)
+		}
+
+		group.cancel()
+		queue.close()
+		if queue.released != nil {
+			<-queue.released
+		}
+	}
+}
+
+func TestOutputQueueBlocksUntilRead(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowBlock, channel: make(chan FlingEvent, 1)}
+	queue.send(testSpoolEvent(0))
+
+	sent := make(chan bool)
+	go func() { sent <- queue.send(testSpoolEvent(1)) }()
+
+	select {
+	case <-sent:
+		t.Fatal("send to a full output didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-queue.channel
+	select {
+	case ok := <-sent:
+		if !ok {
+			t.Error("blocked send reported a drop")
+		}
+	case <-time.After(time.Second):
+		t.Error("send stayed blocked after the output read")
+	}
+}
+
+func TestOutputQueueSendUnlessAborts(t *testing.T) {
+	queue := &outputQueue{name: "out", policy: overflowBlock, channel: make(chan FlingEvent, 1)}
+	queue.send(testSpoolEvent(0))
+
+	abort := make(chan struct{})
+	sent := make(chan bool)
+	go func() { sent <- queue.sendUnless(testSpoolEvent(1), abort) }()
+
+	select {
+	case <-sent:
+		t.Fatal("sendUnless to a full output didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(abort)
+	select {
+	case ok := <-sent:
+		if ok {
+			t.Error("aborted send reported the event as sent")
+		}
+	case <-time.After(time.Second):
+		t.Error("sendUnless didn't return once aborted")
+	}
+	if len(queue.channel) != 1 {
+		t.Errorf("%d events in the output, want just the first", len(queue.channel))
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// number of leading bytes hashed to recognise a file independently of its inode
+const fingerprintBytes = 1024
+
+//FlingRegistry - where and how often file read offsets are persisted
+type FlingRegistry struct {
+	Path          string `json:"path"`
+	FlushInterval int    `json:"flush_interval,omitempty"`
+}
+
+//FlingRegistryEntry - committed read position for a single tailed file
+type FlingRegistryEntry struct {
+	Path            string `json:"path"`
+	Inode           uint64 `json:"inode"`
+	Device          uint64 `json:"device"`
+	Fingerprint     string `json:"fingerprint"`
+	FingerprintSize int64  `json:"fingerprint_size"`
+	Offset          int64  `json:"offset"`
+}
+
+// offsetRegistry - in memory view of the registry file, shared by all file workers
+type offsetRegistry struct {
+	path    string
+	lock    sync.Mutex
+	entries map[string]*FlingRegistryEntry
+	dirty   bool
+	// held for the whole of a flush, so a flush can't return while another is still
+	// writing what it would have written
+	flushing sync.Mutex
+}
+
+// registry is always available so tail restarts within a run resume in place,
+// it is only written to disk when a registry path is configured
+var registry = newOffsetRegistry("")
+
+func newOffsetRegistry(path string) *offsetRegistry {
+	return &offsetRegistry{
+		path:    path,
+		entries: make(map[string]*FlingRegistryEntry),
+	}
+}
+
+func loadRegistry(config FlingRegistry) (*offsetRegistry, error) {
+	reg := newOffsetRegistry(config.Path)
+	if config.Path == "" {
+		return reg, nil
+	}
+
+	contents, readError := ioutil.ReadFile(config.Path)
+	if os.IsNotExist(readError) {
+		return reg, nil
+	} else if readError != nil {
+		return reg, readError
+	}
+
+	var entries []FlingRegistryEntry
+	if parseError := json.Unmarshal(contents, &entries); parseError != nil {
+		return reg, parseError
+	}
+
+	for i := range entries {
+		reg.entries[entries[i].Path] = &entries[i]
+	}
+
+	return reg, nil
+}
+
+// resumeOffset - returns the committed offset for path if the file currently at
+// path is still the one the offset was recorded against
+func (reg *offsetRegistry) resumeOffset(path string) (int64, bool) {
+	identity, err := statIdentity(path)
+	if err != nil {
+		return 0, false
+	}
+
+	// matching reads the files, which mustn't hold up commits from other workers
+	reg.lock.Lock()
+	var candidates []FlingRegistryEntry
+	if entry, ok := reg.entries[path]; ok {
+		candidates = append(candidates, *entry)
+	}
+	// the file may have been renamed onto this path, look it up by identity
+	for entryPath, entry := range reg.entries {
+		if entryPath != path && entry.Inode == identity.Inode && entry.Device == identity.Device {
+			candidates = append(candidates, *entry)
+		}
+	}
+	reg.lock.Unlock()
+
+	for _, entry := range candidates {
+		if entry.matches(identity) {
+			return entry.Offset, entry.Offset <= identity.Offset
+		}
+	}
+
+	return 0, false
+}
+
+// commit - records that everything up to offset in path has been dispatched
+func (reg *offsetRegistry) commit(path string, identity FlingRegistryEntry, offset int64) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	entry, ok := reg.entries[path]
+	if !ok || entry.Inode != identity.Inode || entry.Device != identity.Device {
+		entry = &FlingRegistryEntry{Path: path, Inode: identity.Inode, Device: identity.Device}
+		reg.entries[path] = entry
+	}
+
+	entry.Offset = offset
+	reg.dirty = true
+}
+
+// rename - moves an entry along with a file renamed by fling itself (rotation)
+func (reg *offsetRegistry) rename(from string, to string) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if entry, ok := reg.entries[from]; ok {
+		delete(reg.entries, from)
+		entry.Path = to
+		reg.entries[to] = entry
+		reg.dirty = true
+	}
+}
+
+// forget - drops the entry for a file that has been deleted
+func (reg *offsetRegistry) forget(path string) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if _, ok := reg.entries[path]; ok {
+		delete(reg.entries, path)
+		reg.dirty = true
+	}
+}
+
+// flush - writes the registry to disk if anything changed since the last flush
+func (reg *offsetRegistry) flush() error {
+	reg.flushing.Lock()
+	defer reg.flushing.Unlock()
+
+	reg.lock.Lock()
+	if reg.path == "" || !reg.dirty {
+		reg.lock.Unlock()
+		return nil
+	}
+
+	var entries []FlingRegistryEntry
+	for _, entry := range reg.entries {
+		entries = append(entries, *entry)
+	}
+	reg.dirty = false
+	reg.lock.Unlock()
+
+	// fingerprinting reads the files, so it is done on the copies and stored afterwards
+	for i := range entries {
+		entries[i].refreshFingerprint()
+	}
+	reg.lock.Lock()
+	for _, fingerprinted := range entries {
+		entry, ok := reg.entries[fingerprinted.Path]
+		if ok && entry.Inode == fingerprinted.Inode && entry.Device == fingerprinted.Device && entry.FingerprintSize < fingerprinted.FingerprintSize {
+			entry.Fingerprint = fingerprinted.Fingerprint
+			entry.FingerprintSize = fingerprinted.FingerprintSize
+		}
+	}
+	reg.lock.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	if writeErr := reg.write(entries); writeErr != nil {
+		// try again next flush
+		reg.lock.Lock()
+		reg.dirty = true
+		reg.lock.Unlock()
+		return writeErr
+	}
+
+	return nil
+}
+
+// write - replaces the registry file with entries
+func (reg *offsetRegistry) write(entries []FlingRegistryEntry) error {
+	contents, marshalErr := json.MarshalIndent(entries, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	// write to a temp file and rename over the registry so a crash mid write
+	// never leaves a truncated registry behind
+	tmp, createErr := ioutil.TempFile(filepath.Dir(reg.path), filepath.Base(reg.path)+".tmp")
+	if createErr != nil {
+		return createErr
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, writeErr := tmp.Write(contents); writeErr != nil {
+		tmp.Close()
+		return writeErr
+	}
+	if syncErr := tmp.Sync(); syncErr != nil {
+		tmp.Close()
+		return syncErr
+	}
+	if closeErr := tmp.Close(); closeErr != nil {
+		return closeErr
+	}
+
+	return os.Rename(tmp.Name(), reg.path)
+}
+
+func registryWorker(reg *offsetRegistry, config FlingRegistry) {
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 5
+	}
+
+	for {
+		time.Sleep(time.Duration(config.FlushInterval) * time.Second)
+
+		if flushErr := reg.flush(); flushErr != nil {
+			log.WithFields(log.Fields{
+				"path":  reg.path,
+				"error": flushErr,
+			}).Error("Couldn't write registry")
+		}
+	}
+}
+
+func (entry *FlingRegistryEntry) matches(identity FlingRegistryEntry) bool {
+	if entry.Inode != identity.Inode || entry.Device != identity.Device {
+		return false
+	}
+
+	// inodes get recycled, make sure the content still starts the same way
+	if entry.Fingerprint != "" {
+		fingerprint, size, err := fingerprintFile(identity.Path, entry.FingerprintSize)
+		if err != nil || size != entry.FingerprintSize || fingerprint != entry.Fingerprint {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (entry *FlingRegistryEntry) refreshFingerprint() {
+	if entry.FingerprintSize >= fingerprintBytes {
+		return
+	}
+
+	identity, err := statIdentity(entry.Path)
+	if err != nil || identity.Inode != entry.Inode || identity.Device != entry.Device {
+		return
+	}
+
+	fingerprint, size, err := fingerprintFile(entry.Path, fingerprintBytes)
+	if err == nil && size > 0 {
+		entry.Fingerprint = fingerprint
+		entry.FingerprintSize = size
+	}
+}
+
+// statIdentity - inode and device of the file currently at path, Offset is
+// set to the current size of the file
+func statIdentity(path string) (FlingRegistryEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FlingRegistryEntry{Path: path}, err
+	}
+
+	return infoIdentity(path, info), nil
+}
+
+// infoIdentity - the identity of the file info describes, found at path
+func infoIdentity(path string, info os.FileInfo) FlingRegistryEntry {
+	identity := FlingRegistryEntry{Path: path, Offset: info.Size()}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		identity.Inode = uint64(stat.Ino)
+		identity.Device = uint64(stat.Dev)
+	}
+
+	return identity
+}
+
+// tailedFile - the file a tail is reading, held open so offsets are committed against
+// the file their lines came from even once something else has replaced it at path
+type tailedFile struct {
+	path     string
+	file     *os.File // nil while nothing exists at path
+	identity FlingRegistryEntry
+}
+
+func openTailedFile(path string) *tailedFile {
+	tailed := &tailedFile{path: path}
+	tailed.reopen()
+
+	return tailed
+}
+
+// reopen - switches to the file currently at path
+func (tailed *tailedFile) reopen() {
+	tailed.close()
+	tailed.identity = FlingRegistryEntry{Path: tailed.path}
+
+	file, err := os.Open(tailed.path)
+	if err != nil {
+		return
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	tailed.file = file
+	tailed.identity = infoIdentity(tailed.path, info)
+}
+
+// replaced - whether a line arriving after offset bytes of the file must have come from
+// a different file, because the file was truncated below offset, or because it has been
+// read to the end and path now holds another file (or held none when it was opened)
+func (tailed *tailedFile) replaced(offset int64) bool {
+	if tailed.file == nil {
+		return true
+	}
+
+	info, err := tailed.file.Stat()
+	if err != nil || info.Size() > offset {
+		return false
+	} else if info.Size() < offset {
+		return true
+	}
+
+	current, err := statIdentity(tailed.path)
+	if err != nil {
+		return false
+	}
+
+	return current.Inode != tailed.identity.Inode || current.Device != tailed.identity.Device
+}
+
+func (tailed *tailedFile) close() {
+	if tailed.file != nil {
+		tailed.file.Close()
+		tailed.file = nil
+	}
+}
+
+func fingerprintFile(path string, length int64) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	size, err := io.Copy(hash, io.LimitReader(file, length))
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
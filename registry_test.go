@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, contents string) {
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "app.log")
+	writeTestFile(t, logPath, "one\ntwo\nthree\n")
+	identity, err := statIdentity(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := FlingRegistry{Path: filepath.Join(dir, "registry.json")}
+	reg, err := loadRegistry(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.commit(logPath, identity, 8)
+	if err := reg.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadRegistry(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := loaded.resumeOffset(logPath); !ok || offset != 8 {
+		t.Errorf("resumeOffset = %d, %v, want 8, true", offset, ok)
+	}
+
+	// renamed, as rotation does, the offset follows the file
+	rotated := logPath + ".1"
+	if err := os.Rename(logPath, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if offset, ok := loaded.resumeOffset(rotated); !ok || offset != 8 {
+		t.Errorf("resumeOffset after rename = %d, %v, want 8, true", offset, ok)
+	}
+
+	// rewritten in place, the inode is the same but the fingerprint isn't
+	writeTestFile(t, rotated, "something else entirely\n")
+	if offset, ok := loaded.resumeOffset(rotated); ok {
+		t.Errorf("resumeOffset of rewritten file = %d, want no match", offset)
+	}
+
+	if _, ok := loaded.resumeOffset(logPath); ok {
+		t.Errorf("resumeOffset of missing file matched")
+	}
+}
+
+func TestRegistryOffsetBeyondFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "app.log")
+	writeTestFile(t, logPath, "one\n")
+	identity, _ := statIdentity(logPath)
+
+	reg := newOffsetRegistry("")
+	reg.commit(logPath, identity, 100)
+	if _, ok := reg.resumeOffset(logPath); ok {
+		t.Errorf("resumeOffset past the end of a truncated file matched")
+	}
+}
+
+func TestTailedFileReplaced(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-tailed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "app.log")
+
+	tailed := openTailedFile(logPath)
+	defer tailed.close()
+	if tailed.identity.Inode != 0 {
+		t.Errorf("identity of missing file has inode %d", tailed.identity.Inode)
+	}
+	if !tailed.replaced(0) {
+		t.Errorf("file created after opening isn't reported as replaced")
+	}
+
+	writeTestFile(t, logPath, "one\ntwo\n")
+	tailed.reopen()
+	if tailed.identity.Inode == 0 {
+		t.Fatalf("identity wasn't recomputed on reopen")
+	}
+
+	tests := []struct {
+		name     string
+		change   func()
+		offset   int64
+		replaced bool
+	}{
+		{"unread lines", func() {}, 4, false},
+		{"read to the end", func() {}, 8, false},
+		{"truncated", func() { os.Truncate(logPath, 0) }, 8, true},
+		{"rotated", func() {
+			writeTestFile(t, logPath, "one\ntwo\n")
+			os.Rename(logPath, logPath+".1")
+			writeTestFile(t, logPath, "new\n")
+		}, 8, true},
+		{"rotated with lines left", func() {}, 4, false},
+	}
+
+	for _, test := range tests {
+		test.change()
+		if replaced := tailed.replaced(test.offset); replaced != test.replaced {
+			t.Errorf("%s: replaced(%d) = %v, want %v", test.name, test.offset, replaced, test.replaced)
+		}
+	}
+}
+
+func TestRegistryFlushWaitsForFlushInProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := FlingRegistry{Path: filepath.Join(dir, "registry.json")}
+	reg := newOffsetRegistry(config.Path)
+
+	// plenty of files to fingerprint, so the periodic flush takes a while
+	var paths []string
+	for i := 0; i < 500; i++ {
+		logPath := filepath.Join(dir, fmt.Sprintf("app%d.log", i))
+		writeTestFile(t, logPath, "one\ntwo\n")
+		identity, _ := statIdentity(logPath)
+		reg.commit(logPath, identity, 4)
+		paths = append(paths, logPath)
+	}
+
+	periodic := make(chan error)
+	go func() { periodic <- reg.flush() }()
+
+	// once the periodic flush has taken the changes, shutdown's flush has nothing new
+	// to write, but mustn't return before the periodic one has written them
+	for dirty := true; dirty; {
+		reg.lock.Lock()
+		dirty = reg.dirty
+		reg.lock.Unlock()
+	}
+	if err := reg.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadRegistry(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.entries) != len(paths) {
+		t.Errorf("registry on disk has %d entries once shutdown's flush returned, want %d", len(loaded.entries), len(paths))
+	}
+	if err := <-periodic; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryFlushRetriesAfterFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	reg := newOffsetRegistry(filepath.Join(dir, "missing", "registry.json"))
+	reg.commit("app.log", FlingRegistryEntry{}, 10)
+	if err := reg.flush(); err == nil {
+		t.Fatal("flush into a missing directory succeeded")
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "missing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(reg.path); err != nil {
+		t.Errorf("failed flush wasn't retried: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// run - applies config changes on SIGHUP (or when the config file changes if
+// watchConfig is set) until SIGTERM or SIGINT, then shuts down, returns false
+// if shutdown couldn't drain everything
+func run(pipe *pipeline, path string, watchConfig bool) bool {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	var changes <-chan bool
+	if watchConfig {
+		changes = watchConfigFile(path)
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload(pipe, path)
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				"signal": sig.String(),
+			}).Info("Shutting down")
+
+			// a second signal while shutting down gives up on draining
+			go func() {
+				sig := <-signals
+				log.WithFields(log.Fields{
+					"signal": sig.String(),
+				}).Error("Second signal received, exiting without draining")
+				os.Exit(2)
+			}()
+
+			return pipe.shutdown()
+		case <-changes:
+			reload(pipe, path)
+		}
+	}
+}
+
+// reload - re-reads the config and applies it, keeping the current config on any problem
+func reload(pipe *pipeline, path string) {
+	log.WithFields(log.Fields{"path": path}).Info("Reloading config")
+
+	config, err := loadConfig(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Error("Couldn't load config, keeping the running config")
+		return
+	}
+
+	pipe.apply(config)
+	log.WithFields(log.Fields{"path": path}).Info("Config reloaded")
+}
+
+// watchConfigFile - signals whenever the file at path changes, stat follows symlinks
+// so a kubernetes ConfigMap swapping its ..data link is noticed too
+func watchConfigFile(path string) <-chan bool {
+	changes := make(chan bool)
+
+	go func() {
+		last, _ := os.Stat(path)
+
+		for {
+			time.Sleep(5 * time.Second)
+
+			current, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+
+			if last == nil || !os.SameFile(last, current) || !current.ModTime().Equal(last.ModTime()) || current.Size() != last.Size() {
+				last = current
+				changes <- true
+			}
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// socket input types
+const (
+	socketStream   = "stream"
+	socketDatagram = "datagram"
+	socketFIFO     = "fifo"
+)
+
+//FlingInSocket - a unix socket or named pipe that applications write log lines to
+// instead of a file, each line (or datagram) is read like a line from a FlingInFile
+type FlingInSocket struct {
+	Path         string           `json:"path"`
+	Type         string           `json:"type,omitempty"` // stream (default), datagram or fifo
+	Mode         string           `json:"mode,omitempty"` // octal permissions for the socket or fifo, default 0660
+	IsJSON       bool             `json:"is_json"`
+	MaxLineBytes int              `json:"max_line_bytes,omitempty"` // longer lines are truncated, default 64KB
+	Outputs      []string         `json:"outputs"`
+	Injections   []FlingInjection `json:"injections"`
+}
+
+func (input FlingInSocket) socketType() string {
+	if input.Type == "" {
+		return socketStream
+	}
+
+	return input.Type
+}
+
+func (input FlingInSocket) mode() (os.FileMode, error) {
+	if input.Mode == "" {
+		return 0660, nil
+	}
+
+	mode, err := strconv.ParseUint(input.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q", input.Mode)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+func (input FlingInSocket) maxLineBytes() int {
+	if input.MaxLineBytes == 0 {
+		return 64 * 1024
+	}
+
+	return input.MaxLineBytes
+}
+
+// source - how the input is labelled in metrics and logs
+func (input FlingInSocket) source() string {
+	return input.socketType() + " " + input.Path
+}
+
+func handleInSockets(inputs []FlingInSocket) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input " + input.source(),
+			key:     configKey("socket", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { socketInWorker(group.ctx, input, outputs) })
+			},
+		})
+	}
+
+	return configured
+}
+
+func socketInWorker(ctx context.Context, input FlingInSocket, outputs map[string]interface{}) {
+	file := FlingInFile{
+		Path:       input.Path,
+		IsJSON:     input.IsJSON,
+		Outputs:    input.Outputs,
+		Injections: input.Injections,
+	}
+	processLine := func(line string) {
+		countMetric(linesRead, inputKey, input.source())
+		processInFileLine(line, nil, file, outputs)
+	}
+	fields := log.Fields{"path": input.Path, "type": input.socketType()}
+
+	switch input.socketType() {
+	case socketStream:
+		if !retryUntilDone(ctx, fields, "Couldn't remove stale socket, retrying", func() error { return removeStaleSocket(input.Path) }) {
+			return
+		}
+		listener := listenUntilDone(ctx, "unix", input.Path, nil)
+		if listener == nil {
+			return
+		}
+		setSocketMode(input)
+
+		serveConnections(ctx, listener, func(conn net.Conn) {
+			if err := readLines(conn, input.maxLineBytes(), processLine); err != nil && ctx.Err() == nil {
+				log.WithFields(fields).WithFields(log.Fields{
+					"error": err,
+				}).Error("Couldn't read from socket connection")
+			}
+		})
+	case socketDatagram:
+		if !retryUntilDone(ctx, fields, "Couldn't remove stale socket, retrying", func() error { return removeStaleSocket(input.Path) }) {
+			return
+		}
+		conn := listenPacketUntilDone(ctx, "unixgram", input.Path)
+		if conn == nil {
+			return
+		}
+		defer os.Remove(input.Path)
+		setSocketMode(input)
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+
+		buffer := make([]byte, input.maxLineBytes())
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.WithFields(fields).WithFields(log.Fields{
+						"error": err,
+					}).Error("Couldn't read from socket")
+				}
+				return
+			}
+
+			if line := strings.TrimRight(string(buffer[:n]), "\r\n"); line != "" {
+				processLine(line)
+			}
+		}
+	case socketFIFO:
+		var fifo *os.File
+		opened := retryUntilDone(ctx, fields, "Couldn't open fifo, retrying", func() error {
+			var err error
+			fifo, err = openFIFO(input)
+			return err
+		})
+		if !opened {
+			return
+		}
+		go func() {
+			<-ctx.Done()
+			fifo.Close()
+		}()
+		log.WithFields(fields).Info("Reading fifo")
+
+		if err := readLines(fifo, input.maxLineBytes(), processLine); err != nil && ctx.Err() == nil {
+			log.WithFields(fields).WithFields(log.Fields{
+				"error": err,
+			}).Error("Couldn't read from fifo")
+		}
+	}
+}
+
+// removeStaleSocket - a socket left behind by a previous run stops us listening, anything
+// other than a socket at path is left alone
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	return os.Remove(path)
+}
+
+func setSocketMode(input FlingInSocket) {
+	mode, _ := input.mode()
+	if err := os.Chmod(input.Path, mode); err != nil {
+		log.WithFields(log.Fields{
+			"path":  input.Path,
+			"error": err,
+		}).Error("Couldn't set socket permissions")
+	}
+}
+
+// openFIFO - creates the fifo if needed and opens it for reading, opening it read-write
+// too means there is always a writer so it doesn't hit EOF each time an application closes it
+func openFIFO(input FlingInSocket) (*os.File, error) {
+	mode, _ := input.mode()
+
+	info, err := os.Stat(input.Path)
+	if os.IsNotExist(err) {
+		if err := syscall.Mkfifo(input.Path, uint32(mode)); err != nil {
+			return nil, err
+		}
+		// mkfifo is subject to the umask
+		if err := os.Chmod(input.Path, mode); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if info.Mode()&os.ModeNamedPipe == 0 {
+		return nil, fmt.Errorf("%s exists and is not a fifo", input.Path)
+	}
+
+	return os.OpenFile(input.Path, os.O_RDWR, 0)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// dialTestSocket - connects to the socket at path once the input is listening on it
+func dialTestSocket(t *testing.T, network string, path string) net.Conn {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial(network, path); err == nil {
+			return conn
+		}
+	}
+	t.Fatalf("%s socket %s never started listening", network, path)
+
+	return nil
+}
+
+func TestSocketInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		socketType string
+		write      func(t *testing.T, path string)
+	}{
+		{socketStream, func(t *testing.T, path string) {
+			conn := dialTestSocket(t, "unix", path)
+			defer conn.Close()
+			conn.Write([]byte("one\ntwo\r\nthree"))
+		}},
+		{socketDatagram, func(t *testing.T, path string) {
+			conn := dialTestSocket(t, "unixgram", path)
+			defer conn.Close()
+			// a datagram is one event, newlines in it aren't split on
+			conn.Write([]byte("one\n"))
+			conn.Write([]byte("two\nthree"))
+		}},
+		{socketFIFO, func(t *testing.T, path string) {
+			var fifo *os.File
+			for start := time.Now(); fifo == nil && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+				if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+					fifo, _ = os.OpenFile(path, os.O_WRONLY, 0)
+				}
+			}
+			if fifo == nil {
+				t.Fatalf("fifo %s was never created", path)
+			}
+			defer fifo.Close()
+			fifo.Write([]byte("one\ntwo\nthree\n"))
+		}},
+	}
+	want := map[string][]string{
+		socketStream:   {"one", "two", "three"},
+		socketDatagram: {"one", "two\nthree"},
+		socketFIFO:     {"one", "two", "three"},
+	}
+
+	for _, test := range tests {
+		queue := &outputQueue{name: "out", policy: overflowDropNewest, channel: make(chan FlingEvent, 10)}
+		input := FlingInSocket{
+			Path:    filepath.Join(dir, test.socketType),
+			Type:    test.socketType,
+			Mode:    "0640",
+			Outputs: []string{"out"},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan bool)
+		go func() {
+			socketInWorker(ctx, input, map[string]interface{}{"out": queue})
+			close(stopped)
+		}()
+
+		test.write(t, input.Path)
+		var messages []string
+		for range want[test.socketType] {
+			select {
+			case event := <-queue.channel:
+				messages = append(messages, event.JSON["message"].(string))
+			case <-time.After(5 * time.Second):
+			}
+		}
+		if !reflect.DeepEqual(messages, want[test.socketType]) {
+			t.Errorf("%s: got %q, want %q", test.socketType, messages, want[test.socketType])
+		}
+
+		if info, err := os.Stat(input.Path); err != nil {
+			t.Errorf("%s: %v", test.socketType, err)
+		} else if mode := info.Mode().Perm(); mode != 0640 {
+			t.Errorf("%s: mode %o, want 640", test.socketType, mode)
+		}
+
+		cancel()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: input didn't stop", test.socketType)
+		}
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stale := filepath.Join(dir, "stale.sock")
+	listener, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	if err := removeStaleSocket(stale); err != nil {
+		t.Errorf("stale socket: %v", err)
+	}
+	if _, err := os.Lstat(stale); !os.IsNotExist(err) {
+		t.Error("stale socket wasn't removed")
+	}
+
+	if err := removeStaleSocket(filepath.Join(dir, "missing.sock")); err != nil {
+		t.Errorf("missing socket: %v", err)
+	}
+
+	file := filepath.Join(dir, "app.log")
+	writeTestFile(t, file, "keep me\n")
+	if err := removeStaleSocket(file); err == nil {
+		t.Error("a regular file was accepted as a stale socket")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("regular file was removed: %v", err)
+	}
+}
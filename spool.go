@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingSpool - optional on-disk queue absorbing events an output can't keep up with
+type FlingSpool struct {
+	Path         string `json:"path"`
+	MaxBytes     int64  `json:"max_bytes,omitempty"`
+	MaxAge       int    `json:"max_age,omitempty"` // seconds, 0 keeps events until delivered
+	SegmentBytes int64  `json:"segment_bytes,omitempty"`
+	Fsync        string `json:"fsync,omitempty"` // "always", "interval" (default, every second) or "never"
+}
+
+// diskQueue - append only NDJSON segment files, read back in the order written
+type diskQueue struct {
+	name   string
+	config FlingSpool
+	dir    string
+
+	// segment ids oldest first, the last one is being written to
+	segments   []int64
+	totalBytes int64
+
+	writer      *os.File
+	writeOffset int64
+	unsynced    bool
+
+	reader      *bufio.Reader
+	readerFile  *os.File
+	readID      int64
+	readOffset  int64
+	pending     *FlingEvent
+	pendingSize int64
+
+	// read position sidecar, so events delivered before a restart aren't replayed
+	positionFile  *os.File
+	positionDirty bool
+}
+
+// the read position file in a spool directory, and its fixed width "segment offset" line
+const (
+	spoolPositionName   = "read.position"
+	spoolPositionFormat = "%020d %020d\n"
+)
+
+// spoolOutput - returns the channel dispatchers should send to for an output
+// that spills, events are queued on disk whenever out is full, and a channel closed
+// once the spool directory has been let go of (nil when there is no spool)
+func spoolOutput(name string, spool *FlingSpool, out chan FlingEvent, group *workerGroup) (chan FlingEvent, chan bool) {
+	if spool == nil {
+		return out, nil
+	}
+
+	queue, err := openDiskQueue(name, *spool)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"OutputName": name,
+			"path":       spool.Path,
+			"error":      err,
+		}).Error("Couldn't open spool, output will not be spooled to disk")
+		return out, nil
+	}
+
+	intake := make(chan FlingEvent, 1000)
+	released := make(chan bool)
+	group.start(func() {
+		defer close(released)
+		spoolWorker(queue, intake, out)
+	})
+
+	return intake, released
+}
+
+// spoolWorker - moves events from intake to out, detouring through the disk
+// queue while out is full or older events are still waiting on disk
+func spoolWorker(queue *diskQueue, intake chan FlingEvent, out chan FlingEvent) {
+	defer queue.close()
+
+	syncTicker := time.NewTicker(time.Second)
+	defer syncTicker.Stop()
+
+	for {
+		next, queued := queue.peek()
+
+		if !queued {
+			select {
+			case event, ok := <-intake:
+				if !ok {
+					close(out)
+					return
+				}
+
+				select {
+				case out <- event:
+				default:
+					queue.appendOrSend(event, out)
+				}
+			case <-syncTicker.C:
+				queue.sync()
+			}
+
+			continue
+		}
+
+		select {
+		case event, ok := <-intake:
+			if !ok {
+				// anything still on disk is replayed the next time fling starts
+				close(out)
+				return
+			}
+
+			queue.appendOrSend(event, out)
+		case out <- next:
+			queue.pop()
+		case <-syncTicker.C:
+			queue.sync()
+		}
+	}
+}
+
+func openDiskQueue(name string, config FlingSpool) (*diskQueue, error) {
+	if config.SegmentBytes == 0 {
+		config.SegmentBytes = 16 << 20
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = 1 << 30
+	}
+	if config.Fsync == "" {
+		config.Fsync = "interval"
+	}
+
+	queue := &diskQueue{
+		name:   name,
+		config: config,
+		dir:    filepath.Join(config.Path, name),
+	}
+
+	if mkdirErr := os.MkdirAll(queue.dir, 0755); mkdirErr != nil {
+		return nil, mkdirErr
+	}
+
+	files, readErr := ioutil.ReadDir(queue.dir)
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".seg") {
+			continue
+		}
+		id, parseErr := strconv.ParseInt(strings.TrimSuffix(file.Name(), ".seg"), 10, 64)
+		if parseErr != nil {
+			continue
+		}
+		queue.segments = append(queue.segments, id)
+		queue.totalBytes += file.Size()
+	}
+	sort.Slice(queue.segments, func(i, j int) bool { return queue.segments[i] < queue.segments[j] })
+
+	if len(queue.segments) == 0 {
+		queue.segments = []int64{1}
+	} else {
+		log.WithFields(log.Fields{
+			"OutputName": name,
+			"segments":   len(queue.segments),
+			"bytes":      queue.totalBytes,
+		}).Info("Replaying spooled events")
+
+		// never append to a segment from a previous run, it may end in a partial line
+		queue.segments = append(queue.segments, queue.writeID()+1)
+	}
+
+	if openErr := queue.openWriter(); openErr != nil {
+		return nil, openErr
+	}
+	queue.readID = queue.segments[0]
+
+	if positionErr := queue.openPosition(); positionErr != nil {
+		queue.writer.Close()
+		return nil, positionErr
+	}
+
+	return queue, nil
+}
+
+// openPosition - resumes reading where the last run stopped, if it stopped part way
+// through the oldest segment still on disk
+func (queue *diskQueue) openPosition() error {
+	file, err := os.OpenFile(filepath.Join(queue.dir, spoolPositionName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	queue.positionFile = file
+
+	var id, offset int64
+	if _, scanErr := fmt.Fscanf(file, "%d %d\n", &id, &offset); scanErr != nil || id != queue.readID {
+		return nil
+	}
+	if info, statErr := os.Stat(queue.segmentPath(id)); statErr != nil || offset > info.Size() {
+		return nil
+	}
+	queue.readOffset = offset
+
+	return nil
+}
+
+// savePosition - records the read position, it is synced along with the segments
+func (queue *diskQueue) savePosition() {
+	if _, err := queue.positionFile.WriteAt([]byte(fmt.Sprintf(spoolPositionFormat, queue.readID, queue.readOffset)), 0); err != nil {
+		log.WithFields(log.Fields{
+			"OutputName": queue.name,
+			"error":      err,
+		}).Error("Couldn't save spool read position")
+		return
+	}
+	queue.positionDirty = true
+}
+
+func (queue *diskQueue) segmentPath(id int64) string {
+	return filepath.Join(queue.dir, fmt.Sprintf("%020d.seg", id))
+}
+
+func (queue *diskQueue) writeID() int64 {
+	return queue.segments[len(queue.segments)-1]
+}
+
+func (queue *diskQueue) openWriter() error {
+	file, err := os.OpenFile(queue.segmentPath(queue.writeID()), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	queue.writer = file
+	queue.writeOffset = info.Size()
+
+	return nil
+}
+
+// appendOrSend - queues event on disk, if the disk is unusable fall back to
+// waiting on the output rather than losing the event
+func (queue *diskQueue) appendOrSend(event FlingEvent, out chan FlingEvent) {
+	if appendErr := queue.append(event); appendErr != nil {
+		log.WithFields(log.Fields{
+			"OutputName": queue.name,
+			"error":      appendErr,
+		}).Error("Couldn't spool event to disk, waiting on output instead")
+		out <- event
+	}
+}
+
+func (queue *diskQueue) append(event FlingEvent) error {
+	line, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		return marshalErr
+	}
+	line = append(line, '\n')
+
+	if queue.writeOffset > 0 && queue.writeOffset+int64(len(line)) > queue.config.SegmentBytes {
+		if rotateErr := queue.rotateWriter(); rotateErr != nil {
+			return rotateErr
+		}
+	}
+
+	written, writeErr := queue.writer.Write(line)
+	queue.writeOffset += int64(written)
+	queue.totalBytes += int64(written)
+	if writeErr != nil {
+		return writeErr
+	}
+
+	queue.unsynced = true
+	if queue.config.Fsync == "always" {
+		queue.sync()
+	}
+
+	queue.enforceMaxBytes()
+
+	return nil
+}
+
+func (queue *diskQueue) rotateWriter() error {
+	queue.sync()
+	queue.writer.Close()
+
+	queue.segments = append(queue.segments, queue.writeID()+1)
+
+	return queue.openWriter()
+}
+
+func (queue *diskQueue) sync() {
+	if queue.positionDirty && queue.config.Fsync != "never" {
+		queue.positionFile.Sync()
+	}
+	queue.positionDirty = false
+
+	if !queue.unsynced || queue.config.Fsync == "never" {
+		return
+	}
+
+	if syncErr := queue.writer.Sync(); syncErr != nil {
+		log.WithFields(log.Fields{
+			"OutputName": queue.name,
+			"error":      syncErr,
+		}).Error("Couldn't fsync spool segment")
+	}
+	queue.unsynced = false
+}
+
+// enforceMaxBytes - discards the oldest segments once the queue is over its size limit
+func (queue *diskQueue) enforceMaxBytes() {
+	for queue.totalBytes > queue.config.MaxBytes && len(queue.segments) > 1 {
+		log.WithFields(log.Fields{
+			"OutputName": queue.name,
+			"segment":    queue.segments[0],
+			"max_bytes":  queue.config.MaxBytes,
+		}).Warn("Spool full, discarding oldest segment")
+
+		queue.dropHead()
+	}
+}
+
+// peek - the oldest event on disk without removing it
+func (queue *diskQueue) peek() (FlingEvent, bool) {
+	for queue.pending == nil {
+		if queue.readID == queue.writeID() && queue.readOffset >= queue.writeOffset {
+			return FlingEvent{}, false
+		}
+
+		if queue.reader == nil {
+			if queue.expired(queue.readID) {
+				log.WithFields(log.Fields{
+					"OutputName": queue.name,
+					"segment":    queue.readID,
+					"max_age":    queue.config.MaxAge,
+				}).Warn("Discarding spool segment older than max_age")
+
+				queue.dropHead()
+				continue
+			}
+
+			if openErr := queue.openReader(); openErr != nil {
+				log.WithFields(log.Fields{
+					"OutputName": queue.name,
+					"segment":    queue.readID,
+					"error":      openErr,
+				}).Error("Couldn't read spool segment, discarding it")
+
+				queue.dropHead()
+				continue
+			}
+		}
+
+		line, readErr := queue.reader.ReadBytes('\n')
+		if readErr == io.EOF && queue.readID != queue.writeID() {
+			// finished with a segment that will never be written to again
+			queue.dropHead()
+			continue
+		} else if readErr == io.EOF {
+			// the rest of a line still being written, read it again once it is complete
+			queue.closeReader()
+			return FlingEvent{}, false
+		} else if readErr != nil {
+			log.WithFields(log.Fields{
+				"OutputName": queue.name,
+				"segment":    queue.readID,
+				"error":      readErr,
+			}).Error("Couldn't read spool segment, discarding it")
+
+			queue.dropHead()
+			continue
+		}
+
+		var event FlingEvent
+		if unmarshalErr := json.Unmarshal(line, &event); unmarshalErr != nil {
+			log.WithFields(log.Fields{
+				"OutputName": queue.name,
+				"segment":    queue.readID,
+				"error":      unmarshalErr,
+			}).Error("Skipping corrupt spooled event")
+
+			queue.readOffset += int64(len(line))
+			queue.savePosition()
+			continue
+		}
+
+		queue.pending = &event
+		queue.pendingSize = int64(len(line))
+	}
+
+	return *queue.pending, true
+}
+
+// pop - removes the event last returned by peek
+func (queue *diskQueue) pop() {
+	queue.pending = nil
+	queue.readOffset += queue.pendingSize
+	queue.pendingSize = 0
+	queue.savePosition()
+}
+
+func (queue *diskQueue) openReader() error {
+	file, err := os.Open(queue.segmentPath(queue.readID))
+	if err != nil {
+		return err
+	}
+
+	if _, seekErr := file.Seek(queue.readOffset, io.SeekStart); seekErr != nil {
+		file.Close()
+		return seekErr
+	}
+
+	queue.readerFile = file
+	queue.reader = bufio.NewReader(file)
+
+	return nil
+}
+
+func (queue *diskQueue) closeReader() {
+	if queue.readerFile != nil {
+		queue.readerFile.Close()
+	}
+	queue.readerFile = nil
+	queue.reader = nil
+}
+
+// dropHead - deletes the oldest segment, starting a fresh one if it was also being written
+func (queue *diskQueue) dropHead() {
+	queue.closeReader()
+	queue.pending = nil
+	queue.pendingSize = 0
+
+	id := queue.segments[0]
+	if info, err := os.Stat(queue.segmentPath(id)); err == nil {
+		queue.totalBytes -= info.Size()
+	}
+
+	if id == queue.writeID() {
+		queue.writer.Close()
+		os.Remove(queue.segmentPath(id))
+		queue.segments = []int64{id + 1}
+		if openErr := queue.openWriter(); openErr != nil {
+			log.WithFields(log.Fields{
+				"OutputName": queue.name,
+				"error":      openErr,
+			}).Error("Couldn't create spool segment")
+		}
+	} else {
+		os.Remove(queue.segmentPath(id))
+		queue.segments = queue.segments[1:]
+	}
+
+	queue.readID = queue.segments[0]
+	queue.readOffset = 0
+	queue.savePosition()
+}
+
+func (queue *diskQueue) expired(id int64) bool {
+	if queue.config.MaxAge == 0 {
+		return false
+	}
+
+	info, err := os.Stat(queue.segmentPath(id))
+	if err != nil {
+		return false
+	}
+
+	return time.Since(info.ModTime()) > time.Duration(queue.config.MaxAge)*time.Second
+}
+
+func (queue *diskQueue) close() {
+	queue.sync()
+	queue.closeReader()
+	queue.writer.Close()
+	queue.positionFile.Close()
+}
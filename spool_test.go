@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testSpoolEvent(n int) FlingEvent {
+	return FlingEvent{JSON: map[string]interface{}{"message": fmt.Sprintf("event %d", n)}}
+}
+
+func openTestSpool(t *testing.T, dir string, segmentBytes int64) *diskQueue {
+	queue, err := openDiskQueue("out", FlingSpool{Path: dir, SegmentBytes: segmentBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return queue
+}
+
+// popTestSpool - pops count events, checking they are the ones expected
+func popTestSpool(t *testing.T, queue *diskQueue, first int, count int) {
+	for n := first; n < first+count; n++ {
+		event, ok := queue.peek()
+		if !ok {
+			t.Fatalf("peek: nothing queued, want event %d", n)
+		}
+		if message := event.JSON["message"]; message != fmt.Sprintf("event %d", n) {
+			t.Fatalf("peek = %v, want event %d", message, n)
+		}
+		queue.pop()
+	}
+}
+
+func TestSpoolRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// small segments, so the events span several of them
+	queue := openTestSpool(t, dir, 100)
+	for n := 0; n < 10; n++ {
+		if err := queue.append(testSpoolEvent(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(queue.segments) < 3 {
+		t.Errorf("10 events made %d segments, want several", len(queue.segments))
+	}
+
+	popTestSpool(t, queue, 0, 10)
+	if _, ok := queue.peek(); ok {
+		t.Errorf("peek of drained spool returned an event")
+	}
+	queue.close()
+}
+
+func TestSpoolRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue := openTestSpool(t, dir, 1<<20)
+	for n := 0; n < 6; n++ {
+		queue.append(testSpoolEvent(n))
+	}
+	popTestSpool(t, queue, 0, 2)
+	queue.close()
+
+	// delivered events aren't replayed
+	queue = openTestSpool(t, dir, 1<<20)
+	popTestSpool(t, queue, 2, 2)
+	queue.append(testSpoolEvent(6))
+	queue.close()
+
+	queue = openTestSpool(t, dir, 1<<20)
+	popTestSpool(t, queue, 4, 3)
+	if _, ok := queue.peek(); ok {
+		t.Errorf("peek of drained spool returned an event")
+	}
+	queue.close()
+}
+
+func TestSpoolPartialLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue := openTestSpool(t, dir, 1<<20)
+	defer queue.close()
+	queue.append(testSpoolEvent(0))
+
+	// half of the next event, as if the rest hadn't been written yet
+	line := []byte(`{"UniqueID":"","JSON":{"message":"event 1"}}` + "\n")
+	queue.writer.Write(line[:10])
+	queue.writeOffset += 10
+
+	popTestSpool(t, queue, 0, 1)
+	if _, ok := queue.peek(); ok {
+		t.Fatalf("peek returned a partly written event")
+	}
+	if queue.readID != queue.writeID() {
+		t.Fatalf("partly written segment was discarded")
+	}
+
+	queue.writer.Write(line[10:])
+	queue.writeOffset += int64(len(line) - 10)
+	popTestSpool(t, queue, 1, 1)
+}
+
+func TestSpoolCorruptLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue := openTestSpool(t, dir, 1<<20)
+	defer queue.close()
+
+	garbage := []byte("not json\n")
+	queue.writer.Write(garbage)
+	queue.writeOffset += int64(len(garbage))
+	queue.append(testSpoolEvent(0))
+
+	popTestSpool(t, queue, 0, 1)
+}
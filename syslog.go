@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//FlingInSyslog - syslog listener accepting RFC 3164 and RFC 5424 messages
+type FlingInSyslog struct {
+	Listen          string           `json:"listen"`
+	Protocol        string           `json:"protocol,omitempty"` // udp (default), tcp or tls
+	TLS             *FlingTLS        `json:"tls,omitempty"`
+	MaxMessageBytes int              `json:"max_message_bytes,omitempty"` // default 64KB
+	Outputs         []string         `json:"outputs"`
+	Injections      []FlingInjection `json:"injections"`
+}
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+func (input FlingInSyslog) protocol() string {
+	if input.Protocol == "" {
+		return "udp"
+	}
+
+	return input.Protocol
+}
+
+func (input FlingInSyslog) maxMessageBytes() int {
+	if input.MaxMessageBytes == 0 {
+		return 64 * 1024
+	}
+
+	return input.MaxMessageBytes
+}
+
+// source - how the input is labelled in metrics and logs
+func (input FlingInSyslog) source() string {
+	return "syslog " + input.protocol() + " " + input.Listen
+}
+
+func handleInSyslogs(inputs []FlingInSyslog) []configuredWorker {
+	var configured []configuredWorker
+
+	for _, input := range inputs {
+		input := input
+		configured = append(configured, configuredWorker{
+			name:    "input " + input.source(),
+			key:     configKey("syslog", input),
+			outputs: input.Outputs,
+			start: func(group *workerGroup, outputs map[string]interface{}) {
+				group.start(func() { syslogInWorker(group.ctx, input, outputs) })
+			},
+		})
+	}
+
+	return configured
+}
+
+func syslogInWorker(ctx context.Context, input FlingInSyslog, outputs map[string]interface{}) {
+	if input.protocol() == "udp" {
+		conn := listenPacketUntilDone(ctx, "udp", input.Listen)
+		if conn == nil {
+			return
+		}
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+
+		buffer := make([]byte, input.maxMessageBytes())
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.WithFields(log.Fields{
+						"listen": input.Listen,
+						"error":  err,
+					}).Error("Couldn't read syslog datagram")
+				}
+				return
+			}
+
+			processSyslogMessage(buffer[:n], "udp://"+addr.String(), input, outputs)
+		}
+	}
+
+	var tlsConfig *FlingTLS
+	if input.protocol() == "tls" {
+		tlsConfig = input.TLS
+	}
+	listener := listenUntilDone(ctx, "tcp", input.Listen, tlsConfig)
+	if listener == nil {
+		return
+	}
+
+	serveConnections(ctx, listener, func(conn net.Conn) {
+		sender := input.protocol() + "://" + conn.RemoteAddr().String()
+
+		scanner := newSyslogScanner(conn, input.maxMessageBytes())
+		for scanner.Scan() {
+			processSyslogMessage(scanner.Bytes(), sender, input, outputs)
+		}
+
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			log.WithFields(log.Fields{
+				"listen": input.Listen,
+				"sender": sender,
+				"error":  err,
+			}).Error("Closing syslog connection")
+		}
+	})
+}
+
+// newSyslogScanner - scans the frames sent on a stream connection, with room for a
+// message of maxMessageBytes and the longest octet count that can come in front of it
+func newSyslogScanner(source io.Reader, maxMessageBytes int) *bufio.Scanner {
+	scanner := bufio.NewScanner(source)
+	scanner.Buffer(make([]byte, 4096), maxMessageBytes+len(strconv.Itoa(maxMessageBytes))+1)
+	scanner.Split(syslogFrameSplitter(maxMessageBytes))
+
+	return scanner
+}
+
+// syslogFrameSplitter - RFC 6587 framing, a message starting with a digit is octet
+// counted ("<length> <message>"), anything else runs to the next newline
+func syslogFrameSplitter(maxMessageBytes int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		return splitSyslogFrame(data, atEOF, maxMessageBytes)
+	}
+}
+
+func splitSyslogFrame(data []byte, atEOF bool, maxMessageBytes int) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if data[0] >= '0' && data[0] <= '9' {
+		space := bytes.IndexByte(data, ' ')
+		if space < 0 {
+			if atEOF || len(data) > 10 {
+				return 0, nil, errors.New("invalid octet count")
+			}
+			return 0, nil, nil
+		}
+
+		length, err := strconv.Atoi(string(data[:space]))
+		if err != nil || length <= 0 || length > maxMessageBytes {
+			return 0, nil, errors.New("invalid octet count")
+		}
+		end := space + 1 + length
+		if len(data) < end {
+			if atEOF {
+				return 0, nil, errors.New("connection closed mid message")
+			}
+			return 0, nil, nil
+		}
+
+		return end, data[space+1 : end], nil
+	}
+
+	if newline := bytes.IndexByte(data, '\n'); newline >= 0 {
+		return newline + 1, bytes.TrimRight(data[:newline], "\r"), nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+func processSyslogMessage(data []byte, sender string, input FlingInSyslog, outputs map[string]interface{}) {
+	message := strings.TrimRight(string(data), "\r\n\x00")
+	if message == "" {
+		return
+	}
+
+	countMetric(linesRead, inputKey, input.source())
+
+	logEntry, parseErr := parseSyslog(message, time.Now())
+	if parseErr != nil {
+		log.WithFields(log.Fields{
+			"sender":  sender,
+			"message": message,
+			"error":   parseErr,
+		}).Debug("Couldn't parse syslog message, shipping it as is")
+		countMetric(parseFailures, inputKey, input.source())
+
+		logEntry = map[string]interface{}{"message": message}
+	}
+
+	logEntry["fling.source"] = sender
+
+	if _, ok := logEntry["@timestamp"]; !ok {
+		logEntry["@timestamp"] = get3339Time()
+	}
+
+	handleInjections(&logEntry, input.Injections)
+
+	dispatchEntry(FlingEvent{UniqueID: "", JSON: logEntry}, input.Outputs, outputs)
+}
+
+// parseSyslog - the priority then either an RFC 5424 header, recognised by its
+// version number, or the looser RFC 3164 one
+func parseSyslog(message string, now time.Time) (map[string]interface{}, error) {
+	end := strings.IndexByte(message, '>')
+	if !strings.HasPrefix(message, "<") || end < 2 || end > 4 {
+		return nil, errors.New("missing priority")
+	}
+	priority, err := strconv.Atoi(message[1:end])
+	if err != nil || !isDigits(message[1:end]) || priority < 0 || priority > 191 {
+		return nil, fmt.Errorf("invalid priority %q", message[1:end])
+	}
+
+	logEntry := map[string]interface{}{
+		"facility": syslogFacilities[priority/8],
+		"severity": syslogSeverities[priority%8],
+	}
+	rest := message[end+1:]
+
+	if version, after := nextSyslogField(rest); version != "" && isDigits(version) {
+		return logEntry, parseRFC5424(logEntry, after)
+	}
+
+	parseRFC3164(logEntry, rest, now)
+	return logEntry, nil
+}
+
+// parseRFC5424 - TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG], - marks a nil field
+func parseRFC5424(logEntry map[string]interface{}, rest string) error {
+	var timestamp string
+	timestamp, rest = nextSyslogField(rest)
+	if timestamp != "-" {
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		logEntry["@timestamp"] = parsed.UTC().Format(time.RFC3339Nano)
+	}
+
+	for _, field := range []string{"hostname", "appname", "procid", "msgid"} {
+		var value string
+		value, rest = nextSyslogField(rest)
+		if value != "-" && value != "" {
+			logEntry[field] = value
+		}
+	}
+
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		structured, after, err := parseStructuredData(rest)
+		if err != nil {
+			return err
+		}
+		if len(structured) > 0 {
+			logEntry["structured_data"] = structured
+		}
+		rest = after
+	}
+
+	rest = strings.TrimPrefix(rest, " ")
+	logEntry["message"] = strings.TrimPrefix(rest, "\ufeff")
+
+	return nil
+}
+
+// parseStructuredData - [id name="value" ...] elements, values escape ", \ and ]
+func parseStructuredData(data string) (map[string]interface{}, string, error) {
+	structured := make(map[string]interface{})
+
+	for strings.HasPrefix(data, "[") {
+		idEnd := strings.IndexAny(data, " ]")
+		if idEnd < 0 {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		params := make(map[string]interface{})
+		structured[data[1:idEnd]] = params
+		data = data[idEnd:]
+
+		for strings.HasPrefix(data, " ") {
+			data = strings.TrimLeft(data, " ")
+			equals := strings.Index(data, "=\"")
+			if equals < 0 {
+				return nil, "", errors.New("invalid structured data parameter")
+			}
+			name := data[:equals]
+			data = data[equals+2:]
+
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(data); i++ {
+				if data[i] == '\\' && i+1 < len(data) && strings.IndexByte(`"\]`, data[i+1]) >= 0 {
+					i++
+					value.WriteByte(data[i])
+				} else if data[i] == '"' {
+					data = data[i+1:]
+					closed = true
+					break
+				} else {
+					value.WriteByte(data[i])
+				}
+			}
+			if !closed {
+				return nil, "", errors.New("unterminated structured data value")
+			}
+			params[name] = value.String()
+		}
+
+		if !strings.HasPrefix(data, "]") {
+			return nil, "", errors.New("unterminated structured data")
+		}
+		data = data[1:]
+	}
+
+	return structured, data, nil
+}
+
+// parseRFC3164 - "Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG", senders often leave parts
+// out so anything that doesn't fit is kept in the message
+func parseRFC3164(logEntry map[string]interface{}, rest string, now time.Time) {
+	if len(rest) > len(time.Stamp) {
+		if stamp, err := time.ParseInLocation(time.Stamp, rest[:len(time.Stamp)], now.Location()); err == nil {
+			// the year isn't sent, assume the most recent one that doesn't put the message in the future
+			stamp = stamp.AddDate(now.Year(), 0, 0)
+			if stamp.After(now.Add(24 * time.Hour)) {
+				stamp = stamp.AddDate(-1, 0, 0)
+			}
+			logEntry["@timestamp"] = stamp.UTC().Format(time.RFC3339Nano)
+
+			var hostname string
+			hostname, rest = nextSyslogField(strings.TrimPrefix(rest[len(time.Stamp):], " "))
+			logEntry["hostname"] = hostname
+		}
+	}
+
+	if colon := strings.IndexByte(rest, ':'); colon > 0 && colon <= 48 && !strings.ContainsAny(rest[:colon], " \t") {
+		tag := rest[:colon]
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			logEntry["procid"] = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		logEntry["appname"] = tag
+		rest = strings.TrimPrefix(rest[colon+1:], " ")
+	}
+
+	logEntry["message"] = rest
+}
+
+func nextSyslogField(data string) (string, string) {
+	space := strings.IndexByte(data, ' ')
+	if space < 0 {
+		return data, ""
+	}
+
+	return data[:space], data[space+1:]
+}
+
+func isDigits(value string) bool {
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return value != ""
+}
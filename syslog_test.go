@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		message string
+		want    map[string]interface{}
+		err     bool
+	}{
+		{
+			name:    "rfc 5424",
+			message: `<165>1 2024-03-01T11:59:58.5Z host app 42 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication\]"] hello`,
+			want: map[string]interface{}{
+				"facility": "local4", "severity": "notice", "@timestamp": "2024-03-01T11:59:58.5Z",
+				"hostname": "host", "appname": "app", "procid": "42", "msgid": "ID47",
+				"structured_data": map[string]interface{}{
+					"exampleSDID@32473": map[string]interface{}{"iut": "3", "eventSource": `App"lication]`},
+				},
+				"message": "hello",
+			},
+		},
+		{
+			name:    "rfc 5424 nil fields",
+			message: "<14>1 - - - - - - \ufeffbom",
+			want:    map[string]interface{}{"facility": "user", "severity": "info", "message": "bom"},
+		},
+		{
+			name:    "rfc 3164",
+			message: "<34>Mar  1 11:00:00 mymachine su[123]: 'su root' failed",
+			want: map[string]interface{}{
+				"facility": "auth", "severity": "crit", "@timestamp": "2024-03-01T11:00:00Z",
+				"hostname": "mymachine", "appname": "su", "procid": "123", "message": "'su root' failed",
+			},
+		},
+		{
+			name:    "rfc 3164 from last year",
+			message: "<13>Dec 31 23:59:59 host tag: late",
+			want: map[string]interface{}{
+				"facility": "user", "severity": "notice", "@timestamp": "2023-12-31T23:59:59Z",
+				"hostname": "host", "appname": "tag", "message": "late",
+			},
+		},
+		{
+			name:    "rfc 3164 without header",
+			message: "<0>just text",
+			want:    map[string]interface{}{"facility": "kern", "severity": "emerg", "message": "just text"},
+		},
+		{name: "negative priority", message: "<-1>hello", err: true},
+		{name: "priority too high", message: "<192>hello", err: true},
+		{name: "empty priority", message: "<>hello", err: true},
+		{name: "priority not a number", message: "<1a>hello", err: true},
+		{name: "priority with sign", message: "<+1>hello", err: true},
+		{name: "priority too long", message: "<1234>hello", err: true},
+		{name: "no priority", message: "hello", err: true},
+		{name: "unclosed priority", message: "<13", err: true},
+		{name: "bad timestamp", message: "<13>1 yesterday host app - - - hi", err: true},
+		{name: "unterminated structured data", message: `<13>1 - host app - - [id a="b`, err: true},
+		{name: "structured data without quotes", message: `<13>1 - host app - - [id a=b] hi`, err: true},
+	}
+
+	for _, test := range tests {
+		got, err := parseSyslog(test.message, now)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: parsed %q, want an error", test.name, test.message)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSplitSyslogFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		atEOF   bool
+		advance int
+		token   string
+		err     bool
+	}{
+		{name: "octet counted", data: "5 hello6 world!", advance: 7, token: "hello"},
+		{name: "octet count incomplete", data: "11 hello"},
+		{name: "octet count cut off", data: "11 hello", atEOF: true, err: true},
+		{name: "newline", data: "<13>hi\r\nmore", advance: 8, token: "<13>hi"},
+		{name: "newline incomplete", data: "<13>hi"},
+		{name: "newline at eof", data: "<13>hi", atEOF: true, advance: 6, token: "<13>hi"},
+		{name: "octet count overflows", data: "9223372036854775807 hi", err: true},
+		{name: "octet count too large", data: "1000000 hi", err: true},
+		{name: "octet count zero", data: "0 hi", err: true},
+		{name: "octet count not a number", data: "12a hi", err: true},
+		{name: "digits without a space", data: "12345678901", err: true},
+	}
+
+	for _, test := range tests {
+		advance, token, err := splitSyslogFrame([]byte(test.data), test.atEOF, 64*1024)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: split %q, want an error", test.name, test.data)
+			}
+			continue
+		}
+		if err != nil || advance != test.advance || string(token) != test.token {
+			t.Errorf("%s: got %d %q %v, want %d %q", test.name, advance, token, err, test.advance, test.token)
+		}
+	}
+}
+
+func TestSyslogFrameScanner(t *testing.T) {
+	stream := "5 <13>a<13>b\n6 <13>cd"
+	scanner := newSyslogScanner(strings.NewReader(stream), 1024)
+
+	var frames []string
+	for scanner.Scan() {
+		frames = append(frames, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"<13>a", "<13>b", "<13>cd"}; !reflect.DeepEqual(frames, want) {
+		t.Errorf("frames %q, want %q", frames, want)
+	}
+}
+
+func TestSyslogScannerFramesAtTheLimit(t *testing.T) {
+	maxMessageBytes := 64 * 1024
+	message := "<13>" + strings.Repeat("x", maxMessageBytes-4)
+	stream := fmt.Sprintf("%d %s%s\r\n", len(message), message, message)
+
+	scanner := newSyslogScanner(strings.NewReader(stream), maxMessageBytes)
+	var frames []string
+	for scanner.Scan() {
+		frames = append(frames, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || frames[0] != message || frames[1] != message {
+		t.Errorf("got %d frames, want the octet counted and the newline framed message at the limit", len(frames))
+	}
+}
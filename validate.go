@@ -0,0 +1,563 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// configProblems - every problem found in a config, reported together
+type configProblems []string
+
+func (problems configProblems) Error() string {
+	return strings.Join(problems, "; ")
+}
+
+// configValidator - collects problems (which stop fling from starting) and
+// warnings (which don't) while walking a FlingConfig
+type configValidator struct {
+	problems configProblems
+	warnings []string
+
+	// output name -> output type, and which of them something sends to
+	outputs    map[string]string
+	referenced map[string]bool
+}
+
+// validateConfig - semantic checks on a parsed config, beyond what json.Unmarshal catches
+func validateConfig(config FlingConfig) (configProblems, []string) {
+	v := &configValidator{
+		outputs:    make(map[string]string),
+		referenced: make(map[string]bool),
+	}
+
+	v.validateOutputs(config.Output)
+	v.validateInputs(config.Input)
+	v.validateRotations(config.Rotations)
+
+	if len(config.Files) > 0 {
+		v.problemf("top level files are ignored, move them to input.files")
+	}
+	if config.Registry.FlushInterval < 0 {
+		v.problemf("registry flush_interval must not be negative")
+	}
+	if config.Monitoring.Listen != "" {
+		if _, _, err := net.SplitHostPort(config.Monitoring.Listen); err != nil {
+			v.problemf("monitoring listen %q is not a host:port address: %v", config.Monitoring.Listen, err)
+		}
+	}
+	if config.Monitoring.UnhealthyAfter < 0 {
+		v.problemf("monitoring unhealthy_after must not be negative")
+	}
+	if config.ShutdownTimeout < 0 {
+		v.problemf("shutdown_timeout must not be negative")
+	}
+
+	var unused []string
+	for name := range v.outputs {
+		if !v.referenced[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		v.warnf("%s output %q is not used by any input", v.outputs[name], name)
+	}
+
+	return v.problems, v.warnings
+}
+
+func (v *configValidator) problemf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *configValidator) warnf(format string, args ...interface{}) {
+	v.warnings = append(v.warnings, fmt.Sprintf(format, args...))
+}
+
+func (v *configValidator) validateOutputs(outputs FlingOutput) {
+	for _, output := range outputs.PubSubs {
+		v.addOutput("pubsub", output.Name, output.FlingOutputQueue)
+		if output.Project == "" {
+			v.problemf("pubsub output %q has no project", output.Name)
+		}
+		if output.Topic == "" {
+			v.problemf("pubsub output %q has no topic", output.Name)
+		}
+		if output.Retry.MaxAttempts < 0 || output.Retry.InitialBackoff < 0 || output.Retry.MaxBackoff < 0 {
+			v.problemf("pubsub output %q retry settings must not be negative", output.Name)
+		}
+		if output.MaxOutstanding < 0 {
+			v.problemf("pubsub output %q max_outstanding must not be negative", output.Name)
+		}
+	}
+
+	for _, output := range outputs.Loggers {
+		v.addOutput("logger", output.Name, output.FlingOutputQueue)
+	}
+
+	for _, output := range outputs.Elastics {
+		v.addOutput("elasticsearch", output.Name, output.FlingOutputQueue)
+		if len(output.Hosts) == 0 {
+			v.problemf("elasticsearch output %q has no hosts", output.Name)
+		}
+	}
+
+	for _, output := range outputs.BigQueries {
+		v.addOutput("bigquery", output.Name, output.FlingOutputQueue)
+		if output.ProjectID == "" {
+			v.problemf("bigquery output %q has no project_id", output.Name)
+		}
+		if output.BatchSize < 0 || output.BatchTimeout < 0 {
+			v.problemf("bigquery output %q batch settings must not be negative", output.Name)
+		}
+	}
+
+	// dead letter targets can only be checked once every output name is known
+	for _, output := range outputs.PubSubs {
+		if output.DeadLetter == nil {
+			continue
+		}
+		if output.DeadLetter.Path == "" && output.DeadLetter.Output == "" {
+			v.problemf("pubsub output %q dead_letter needs a path or an output", output.Name)
+		}
+		if output.DeadLetter.Output == output.Name {
+			v.problemf("pubsub output %q can't dead letter to itself", output.Name)
+		} else if output.DeadLetter.Output != "" {
+			v.reference(fmt.Sprintf("pubsub output %q dead_letter", output.Name), output.DeadLetter.Output)
+		}
+	}
+	v.validateDeadLetterChains(outputs.PubSubs)
+}
+
+// validateDeadLetterChains - outputs dead lettering to each other in a loop would pass a
+// failing event around forever
+func (v *configValidator) validateDeadLetterChains(outputs []FlingOutPubSub) {
+	next := make(map[string]string)
+	for _, output := range outputs {
+		if output.DeadLetter != nil && output.DeadLetter.Output != "" && output.DeadLetter.Output != output.Name {
+			next[output.Name] = output.DeadLetter.Output
+		}
+	}
+
+	reported := make(map[string]bool)
+	for _, output := range outputs {
+		chain := []string{output.Name}
+		seen := map[string]bool{output.Name: true}
+		for name := next[output.Name]; name != ""; name = next[name] {
+			chain = append(chain, name)
+			if name == output.Name {
+				break
+			}
+			if seen[name] {
+				// a loop further along the chain, reported when walking from one of its outputs
+				chain = nil
+				break
+			}
+			seen[name] = true
+		}
+
+		if len(chain) < 2 || chain[len(chain)-1] != output.Name {
+			continue
+		}
+		// each loop once, from whichever of its outputs is configured first
+		if reported[output.Name] {
+			continue
+		}
+		for _, name := range chain {
+			reported[name] = true
+		}
+		v.problemf("pubsub outputs dead letter to each other in a loop: %s", strings.Join(chain, " -> "))
+	}
+}
+
+func (v *configValidator) addOutput(outputType string, name string, queue FlingOutputQueue) {
+	if name == "" {
+		v.problemf("%s output has no name", outputType)
+		return
+	}
+	if existing, exists := v.outputs[name]; exists {
+		v.problemf("output name %q is used by both a %s and a %s output", name, existing, outputType)
+		return
+	}
+	v.outputs[name] = outputType
+
+	if queue.BufferSize < 0 {
+		v.problemf("output %q buffer_size must not be negative", name)
+	}
+
+	switch queue.policy() {
+	case overflowBlock, overflowDropNewest, overflowDropOldest:
+	case overflowSpill:
+		if queue.Spool == nil {
+			v.problemf("output %q overflow spill needs a spool", name)
+		}
+	default:
+		v.problemf("output %q has unknown overflow policy %q", name, queue.Overflow)
+	}
+
+	if queue.Spool != nil {
+		if queue.Spool.Path == "" {
+			v.problemf("output %q spool has no path", name)
+		}
+		if queue.Spool.MaxBytes < 0 || queue.Spool.MaxAge < 0 || queue.Spool.SegmentBytes < 0 {
+			v.problemf("output %q spool limits must not be negative", name)
+		}
+		switch queue.Spool.Fsync {
+		case "", "always", "interval", "never":
+		default:
+			v.problemf("output %q spool has unknown fsync policy %q", name, queue.Spool.Fsync)
+		}
+	}
+}
+
+// output types that can be configured but that handleOutputs doesn't start yet
+var unstartedOutputTypes = map[string]bool{
+	"elasticsearch": true,
+	"bigquery":      true,
+}
+
+// reference - records that source sends to output name, which must exist
+func (v *configValidator) reference(source string, name string) {
+	outputType, exists := v.outputs[name]
+	if !exists {
+		v.problemf("%s references undefined output %q", source, name)
+		return
+	}
+	if unstartedOutputTypes[outputType] {
+		v.problemf("%s references %s output %q, which fling can't run yet", source, outputType, name)
+	}
+	v.referenced[name] = true
+}
+
+func (v *configValidator) validateInputs(input FlingInput) {
+	for i, file := range input.Files {
+		source := fmt.Sprintf("input file %q", file.Path)
+		if file.Path == "" {
+			source = fmt.Sprintf("input file #%d", i+1)
+			v.problemf("%s has no path", source)
+		} else if file.IsGlob {
+			if _, err := filepath.Match(file.Path, ""); err != nil {
+				v.problemf("%s is not a valid glob: %v", source, err)
+			}
+		}
+		if file.GlobInterval < 0 {
+			v.problemf("%s glob_interval must not be negative", source)
+		}
+		for _, pattern := range file.Exclude {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				v.problemf("%s exclude %q is not a valid glob: %v", source, pattern, err)
+			}
+		}
+		if file.IgnoreOlder < 0 {
+			v.problemf("%s ignore_older must not be negative", source)
+		}
+		v.validateStartPosition(source, file.StartPosition)
+		if file.Multiline != nil {
+			v.validateMultiline(source, file.Multiline)
+		}
+		if file.Parser != nil {
+			if file.IsJSON {
+				v.problemf("%s has both is_json and a parser", source)
+			}
+			v.validateParser(source, file.Parser)
+		}
+
+		v.validateRouting(source, file.Outputs, file.Injections)
+	}
+
+	for i, sub := range input.PubSubs {
+		source := fmt.Sprintf("input pubsub %q", sub.Subscription)
+		if sub.Subscription == "" {
+			source = fmt.Sprintf("input pubsub #%d", i+1)
+			v.problemf("%s has no subscription", source)
+		}
+		if sub.Project == "" {
+			v.problemf("%s has no project", source)
+		}
+		if sub.NumGoroutines < 0 {
+			v.problemf("%s num_goroutines must not be negative", source)
+		}
+
+		v.validateRouting(source, sub.Outputs, sub.Injections)
+	}
+
+	for _, input := range input.Kubernetes {
+		source := fmt.Sprintf("input kubernetes %q", input.path())
+		if _, err := filepath.Match(input.path(), ""); err != nil {
+			v.problemf("%s is not a valid glob: %v", source, err)
+		}
+		if input.GlobInterval < 0 || input.MaxLineBytes < 0 {
+			v.problemf("%s glob_interval and max_line_bytes must not be negative", source)
+		}
+		v.validateStartPosition(source, input.StartPosition)
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+
+	for _, input := range input.Syslogs {
+		source := "input " + input.source()
+		v.validateListen(source, input.Listen, input.TLS)
+		switch input.protocol() {
+		case "udp", "tcp":
+			if input.TLS != nil {
+				v.problemf("%s has tls settings but protocol %s, use protocol tls", source, input.protocol())
+			}
+		case "tls":
+			if input.TLS == nil {
+				v.problemf("%s protocol tls needs tls settings", source)
+			}
+		default:
+			v.problemf("%s has unknown protocol %q, expected udp, tcp or tls", source, input.Protocol)
+		}
+		if input.MaxMessageBytes < 0 {
+			v.problemf("%s max_message_bytes must not be negative", source)
+		}
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+
+	for i, input := range input.Execs {
+		source := fmt.Sprintf("input exec %q", input.source())
+		if input.Command == "" {
+			source = fmt.Sprintf("input exec #%d", i+1)
+			v.problemf("%s has no command", source)
+		}
+		switch input.restart() {
+		case restartAlways, restartOnFailure, restartNever:
+		default:
+			v.problemf("%s has unknown restart policy %q, expected always, on_failure or never", source, input.Restart)
+		}
+		if input.StopTimeout < 0 || input.MaxLineBytes < 0 {
+			v.problemf("%s stop_timeout and max_line_bytes must not be negative", source)
+		}
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+
+	for _, input := range input.HTTPs {
+		source := "input " + input.source()
+		v.validateListen(source, input.Listen, input.TLS)
+		if !strings.HasPrefix(input.path(), "/") {
+			v.problemf("%s path must start with /", source)
+		}
+		if input.Token != "" && input.TokenEnv != "" {
+			v.problemf("%s sets both token and token_env", source)
+		}
+		if input.MaxBodyBytes < 0 {
+			v.problemf("%s max_body_bytes must not be negative", source)
+		}
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+
+	for i, input := range input.Sockets {
+		source := "input " + input.source()
+		if input.Path == "" {
+			source = fmt.Sprintf("input socket #%d", i+1)
+			v.problemf("%s has no path", source)
+		}
+		switch input.socketType() {
+		case socketStream, socketDatagram, socketFIFO:
+		default:
+			v.problemf("%s has unknown type %q, expected stream, datagram or fifo", source, input.Type)
+		}
+		if _, err := input.mode(); err != nil {
+			v.problemf("%s %v", source, err)
+		}
+		if input.MaxLineBytes < 0 {
+			v.problemf("%s max_line_bytes must not be negative", source)
+		}
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+
+	for _, input := range input.Forwards {
+		source := "input " + input.source()
+		v.validateListen(source, input.Listen, input.TLS)
+		if input.MaxMessageBytes < 0 {
+			v.problemf("%s max_message_bytes must not be negative", source)
+		}
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+	for _, input := range input.OTLPs {
+		source := "input " + input.source()
+		v.validateListen(source, input.Listen, input.TLS)
+		if input.MaxMessageBytes < 0 {
+			v.problemf("%s max_message_bytes must not be negative", source)
+		}
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+
+	for _, input := range input.GELFs {
+		source := "input " + input.source()
+		v.validateListen(source, input.Listen, input.TLS)
+		switch input.protocol() {
+		case "udp", "tcp":
+			if input.TLS != nil {
+				v.problemf("%s has tls settings but protocol %s, use protocol tls", source, input.protocol())
+			}
+		case "tls":
+			if input.TLS == nil {
+				v.problemf("%s protocol tls needs tls settings", source)
+			}
+		default:
+			v.problemf("%s has unknown protocol %q, expected udp, tcp or tls", source, input.Protocol)
+		}
+		if input.ChunkTimeout < 0 {
+			v.problemf("%s chunk_timeout must not be negative", source)
+		}
+		if input.MaxMessageBytes < 0 {
+			v.problemf("%s max_message_bytes must not be negative", source)
+		}
+
+		v.validateRouting(source, input.Outputs, input.Injections)
+	}
+}
+
+// validateListen - address and TLS settings shared by inputs that listen on the network
+func (v *configValidator) validateListen(source string, listen string, tls *FlingTLS) {
+	if listen == "" {
+		v.problemf("%s has no listen address", source)
+	} else if _, _, err := net.SplitHostPort(listen); err != nil {
+		v.problemf("%s listen %q is not a host:port address: %v", source, listen, err)
+	}
+
+	if tls != nil && (tls.CertFile == "" || tls.KeyFile == "") {
+		v.problemf("%s tls needs a cert_file and key_file", source)
+	}
+}
+
+// validateParser - a parser type that exists, with the settings it needs
+func (v *configValidator) validateParser(source string, parser *FlingParser) {
+	if _, err := parser.newLineParser(); err != nil {
+		v.problemf("%s parser: %v", source, err)
+	}
+
+	for field, hint := range parser.Types {
+		if !validTypeHint(hint) {
+			v.problemf("%s parser type %q for %s is not int, float, bool, timestamp or timestamp:<layout>", source, hint, field)
+		}
+	}
+
+	switch parser.onNoMatch() {
+	case noMatchKeep, noMatchDrop, noMatchTag:
+	default:
+		v.problemf("%s parser has unknown on_no_match %q, expected keep, drop or tag", source, parser.OnNoMatch)
+	}
+}
+
+// validateMultiline - one pattern that compiles, and limits that make sense
+func (v *configValidator) validateMultiline(source string, multiline *FlingMultiline) {
+	pattern := multiline.StartPattern
+	if (multiline.StartPattern == "") == (multiline.ContinuationPattern == "") {
+		v.problemf("%s multiline needs exactly one of start_pattern and continuation_pattern", source)
+	} else if multiline.StartPattern == "" {
+		pattern = multiline.ContinuationPattern
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		v.problemf("%s multiline pattern %q is not a valid regular expression: %v", source, pattern, err)
+	}
+
+	if multiline.MaxLines < 0 {
+		v.problemf("%s multiline max_lines must not be negative", source)
+	}
+	if multiline.MaxBytes < 0 {
+		v.problemf("%s multiline max_bytes must not be negative", source)
+	}
+	if multiline.FlushTimeout < 0 {
+		v.problemf("%s multiline flush_timeout must not be negative", source)
+	}
+}
+
+func (v *configValidator) validateStartPosition(source string, position string) {
+	switch position {
+	case "", "beginning", "end":
+	default:
+		v.problemf("%s has unknown start_position %q, expected beginning or end", source, position)
+	}
+}
+
+// validateRouting - the outputs and injections every input type shares
+func (v *configValidator) validateRouting(source string, outputs []string, injections []FlingInjection) {
+	if len(outputs) == 0 {
+		v.problemf("%s has no outputs", source)
+	}
+	seen := make(map[string]bool)
+	for _, output := range outputs {
+		if seen[output] {
+			v.problemf("%s lists output %q more than once", source, output)
+			continue
+		}
+		seen[output] = true
+		v.reference(source, output)
+	}
+
+	for i, injection := range injections {
+		if injection.Field == "" {
+			v.problemf("%s injection #%d has no field", source, i+1)
+		}
+
+		sources := 0
+		if injection.Value != "" {
+			sources++
+		}
+		if injection.ENVValue != "" {
+			sources++
+		}
+		if injection.Hostname {
+			sources++
+		}
+		if sources != 1 {
+			v.problemf("%s injection %q must set exactly one of value, env_value or hostname", source, injection.Field)
+		}
+	}
+}
+
+func (v *configValidator) validateRotations(rotations []FlingRotation) {
+	for i, rotation := range rotations {
+		source := fmt.Sprintf("rotation #%d", i+1)
+		if len(rotation.Files) == 0 {
+			v.problemf("%s has no files", source)
+		}
+		for _, path := range rotation.Files {
+			if path == "" {
+				v.problemf("%s has an empty file path", source)
+			} else if _, err := filepath.Match(path, ""); err != nil {
+				v.problemf("%s file %q is not a valid glob: %v", source, path, err)
+			}
+		}
+		if rotation.RotateInterval <= 0 {
+			v.problemf("%s rotate_interval must be a positive number of seconds", source)
+		}
+	}
+}
+
+// validateConfigFile - the validate command, prints every problem and returns the exit status
+func validateConfigFile(path string) int {
+	config, err := readConfig(path)
+	if err != nil {
+		fmt.Printf("%s: %v\n", path, err)
+		return 1
+	}
+
+	problems, warnings := validateConfig(config)
+	for _, warning := range warnings {
+		fmt.Printf("%s: warning: %s\n", path, warning)
+	}
+	for _, problem := range problems {
+		fmt.Printf("%s: %s\n", path, problem)
+	}
+
+	if len(problems) > 0 {
+		return 1
+	}
+
+	fmt.Printf("%s: OK\n", path)
+	return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateUnstartedOutputs(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  FlingOutput
+		problem string
+	}{
+		{"elasticsearch", FlingOutput{Elastics: []FlingOutElastic{{Name: "out", Hosts: []string{"http://es:9200"}}}}, `elasticsearch output "out", which fling can't run yet`},
+		{"bigquery", FlingOutput{BigQueries: []FlingOutBigQuery{{Name: "out", ProjectID: "p"}}}, `bigquery output "out", which fling can't run yet`},
+		{"logger", FlingOutput{Loggers: []FlingOutLogger{{Name: "out"}}}, ""},
+	}
+
+	for _, test := range tests {
+		config := FlingConfig{
+			Input:  FlingInput{Files: []FlingInFile{{Path: "/tmp/app.log", Outputs: []string{"out"}}}},
+			Output: test.output,
+		}
+		problems, _ := validateConfig(config)
+
+		switch {
+		case test.problem == "" && len(problems) > 0:
+			t.Errorf("%s: unexpected problems %v", test.name, problems)
+		case test.problem != "" && (len(problems) != 1 || !strings.Contains(problems[0], test.problem)):
+			t.Errorf("%s: problems %v, want %q", test.name, problems, test.problem)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	config := FlingConfig{
+		Input:           FlingInput{Files: []FlingInFile{{Path: "/tmp/app.log", Outputs: []string{"missing"}}}},
+		Output:          FlingOutput{Loggers: []FlingOutLogger{{Name: "out"}, {Name: "out"}}},
+		Registry:        FlingRegistry{FlushInterval: -1},
+		Monitoring:      FlingMonitoring{Listen: "8080"},
+		ShutdownTimeout: -1,
+	}
+	want := []string{
+		`output name "out" is used by both`,
+		`undefined output "missing"`,
+		"flush_interval must not be negative",
+		`monitoring listen "8080"`,
+		"shutdown_timeout must not be negative",
+	}
+
+	problems, _ := validateConfig(config)
+	for _, problem := range want {
+		found := false
+		for _, got := range problems {
+			found = found || strings.Contains(got, problem)
+		}
+		if !found {
+			t.Errorf("problems %v, missing %q", problems, problem)
+		}
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fling-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		config string
+		status int
+	}{
+		{"valid", `{"input": {"files": [{"path": "/tmp/app.log", "outputs": ["out"]}]}, "output": {"logger": [{"name": "out"}]}}`, 0},
+		{"invalid", `{"input": {"files": [{"path": "/tmp/app.log", "outputs": ["missing"]}]}, "output": {"logger": [{"name": "out"}]}}`, 1},
+		{"not json", `{"input": `, 1},
+		{"missing", "", 1},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name+".json")
+		if test.config != "" {
+			writeTestFile(t, path, test.config)
+		}
+
+		if status := validateConfigFile(path); status != test.status {
+			t.Errorf("%s: exit status %d, want %d", test.name, status, test.status)
+		}
+	}
+}